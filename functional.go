@@ -1,33 +1,4846 @@
 package fngo
 
 import (
+	"bufio"
+	"bytes"
+	"container/heap"
 	"context"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"iter"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
 )
 
 // Pipeline is a connection between two processing stages working on type T.
 type Pipeline[T any] struct {
 	ctx    context.Context
-	group  *errgroup.Group
+	group  *stageGroup
+	name   string
 	values chan T
 }
 
+// Named returns a copy of the Pipeline tagged with the given name for diagnostic purposes. Errors arising in the stages
+// that consume it, including panics recovered as a *PanicError, are prefixed with the name. Stages after those carry the
+// name forward until another is given. Naming a Pipeline has no effect on the flow of values.
+func (p Pipeline[T]) Named(name string) Pipeline[T] {
+	p.name = name
+	return p
+}
+
+// run starts the given function as a stage of the Pipeline's errgroup. If the Pipeline has been named, any error the
+// stage returns is prefixed with the name.
+func (p Pipeline[T]) run(stage func() error) {
+	p.group.Go(func() error {
+		return p.wrap(stage())
+	})
+}
+
+// wrap prefixes the given error with the Pipeline's name, if it has one. Nil and errStopped are returned unchanged.
+func (p Pipeline[T]) wrap(err error) error {
+	if p.name == "" || err == nil || err == errStopped {
+		return err
+	}
+	return fmt.Errorf("%s: %w", p.name, err)
+}
+
+// wait blocks until every stage in the Pipeline's errgroup has finished. It must be called exactly once by whichever
+// terminal processing stage consumes the Pipeline, after that stage has started.
+func (p Pipeline[T]) wait() error {
+	return p.group.wait()
+}
+
+// ErrCircuitOpen is the error given for values rejected by an open circuit breaker.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// ErrDuplicateKey is returned by ToMapUnique when two values produce the same key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ErrIdleTimeout is the error with which IdleTimeout fails a Pipeline that has gone quiet for too long.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// ErrIncompleteTuple is returned by Tuples2Strict and Tuples3Strict when values are left over at the end of the stream.
+var ErrIncompleteTuple = errors.New("incomplete tuple")
+
+// ErrMultipleValues is returned by Single when the Pipeline produces more than one value.
+var ErrMultipleValues = errors.New("multiple values")
+
+// ErrNaN is the error with which PercentileWindow and MovingMedian fail a Pipeline upon receiving NaN, which has no
+// place in an ordering of numbers.
+var ErrNaN = errors.New("NaN value")
+
+// ErrNilPointer is returned by DerefStrict upon encountering a nil pointer.
+var ErrNilPointer = errors.New("nil pointer")
+
+// ErrNoValues is returned by Single when the Pipeline produces no values.
+var ErrNoValues = errors.New("no values")
+
+// ErrOutOfOrder is the error with which Monotonic in ErrorMode fails a Pipeline upon a value whose key regresses.
+var ErrOutOfOrder = errors.New("out of order")
+
+// autoScaleIdle is how long an extra worker started by AutoParallelMap may sit idle before stopping.
+const autoScaleIdle = time.Second
+
+// countWindowBuckets is the number of buckets into which CountWindow divides its window.
+const countWindowBuckets = 16
+
+// errStopped is returned by a terminal processing stage that needs no further values in order to cancel the rest of
+// the Pipeline. It is never passed on to the caller.
+var errStopped = errors.New("pipeline stopped")
+
+// partitionFilesOpen is the number of files PartitionedFileSink keeps open at once.
+const partitionFilesOpen = 64
+
+// sortExternalFanIn is the number of runs SortExternal merges at once, and so the most files it keeps open.
+const sortExternalFanIn = 64
+
+// timingsSample is the number of durations a Timings keeps for estimating percentiles.
+const timingsSample = 1024
+
+// BreakerSettings configures the circuit breaker used by CircuitBreaker and CircuitBreakerFallback. The breaker trips
+// open after Threshold consecutive failures and rejects values for the following Cooldown. After that it lets a single
+// trial value through: success closes the breaker again, while failure reopens it for another Cooldown.
+type BreakerSettings struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// Codec converts values of type T to and from a stream of bytes. Encode writes a single value, and Decode reads back the
+// next one, returning io.EOF once the stream is exhausted. The encoding of each value must therefore be self-delimiting.
+type Codec[T any] interface {
+	Encode(w io.Writer, value T) error
+	Decode(r io.Reader) (T, error)
+}
+
+// Count is a value of type T accompanied by a number of occurrences.
+type Count[T any] struct {
+	Value T
+	N     int
+}
+
+// FlatItem is a value of type T produced by FlattenWithMarkers, annotated with its position in the originating slice.
+// Last is set on the final value of each slice. An empty slice is represented by a single FlatItem with Empty and Last
+// both set and a zero Value.
+type FlatItem[T any] struct {
+	Value T
+	Last  bool
+	Empty bool
+}
+
+// Gap is a range of missing sequence numbers, From through To inclusive, as reported by FindGaps.
+type Gap struct {
+	From int64
+	To   int64
+}
+
+// Iterator is a source of values of type T backed by some resource, such as database rows. Next returns the next value
+// along with true, or false once the values are exhausted. Close releases the underlying resource.
+type Iterator[T any] interface {
+	Next() (T, bool, error)
+	Close() error
+}
+
+// MonotonicMode determines what Monotonic does with a value whose key is less than that of the last value passed on.
+type MonotonicMode int
+
+const (
+	// DropMode silently drops the value.
+	DropMode MonotonicMode = iota
+
+	// ErrorMode aborts the Pipeline with ErrOutOfOrder.
+	ErrorMode
+)
+
+// Number is a constraint permitting any integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Pair is a combination of two values of types A and B.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// PanicError is the error produced when a function given to a processing stage panics. It carries the value passed to
+// panic along with the stack trace of the panicking goroutine.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Result is a value of type T paired with an error. Which of the two is meaningful depends on where it is used.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Retryer decides whether a failed operation should be attempted again. Next is given the number of the attempt that
+// just failed, counting from 1, along with its error, and returns whether to retry and how long to wait beforehand.
+type Retryer interface {
+	Next(attempt int, err error) (delay time.Duration, retry bool)
+}
+
+// RetryerFunc adapts an ordinary function to the Retryer interface.
+type RetryerFunc func(attempt int, err error) (time.Duration, bool)
+
+// Next calls f(attempt, err).
+func (f RetryerFunc) Next(attempt int, err error) (time.Duration, bool) {
+	return f(attempt, err)
+}
+
+// Rewindable holds the values recorded by Record so they can be replayed. It is safe for concurrent use.
+type Rewindable[T any] struct {
+	ctx context.Context
+
+	mu     sync.Mutex
+	values []T
+}
+
+// Replay is a helper function around SliceSource that generates the values recorded so far, in the order they
+// arrived. Each replay runs within a new errgroup created from the Context given to Record, independent of the original
+// Pipeline, and may be called any number of times, even while recording continues.
+func (r *Rewindable[T]) Replay() Pipeline[T] {
+	r.mu.Lock()
+	values := make([]T, len(r.values))
+	copy(values, r.values)
+	r.mu.Unlock()
+
+	return SliceSource(r.ctx, values)
+}
+
+// Summary describes a sequence of numbers. All fields other than Count are converted to float64.
+type Summary struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+	Mean  float64
+}
+
+// Tagged is a value of type T accompanied by the index of the input Pipeline it came from.
+type Tagged[T any] struct {
+	Source int
+	Value  T
+}
+
+// Timings accumulates the durations of operations, such as the mapper calls measured by MapTimed. It is safe for
+// concurrent use, and its zero value is ready to use. Percentiles are estimated from a uniform random sample of the
+// durations, so memory use stays constant however many are recorded.
+type Timings struct {
+	mu     sync.Mutex
+	count  int
+	total  time.Duration
+	min    time.Duration
+	max    time.Duration
+	sample []time.Duration
+	rng    *rand.Rand
+}
+
+// Count returns the number of durations recorded.
+func (t *Timings) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.count
+}
+
+// Max returns the longest duration recorded, or zero if there are none.
+func (t *Timings) Max() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.max
+}
+
+// Mean returns the average duration recorded, or zero if there are none.
+func (t *Timings) Mean() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == 0 {
+		return 0
+	}
+	return t.total / time.Duration(t.count)
+}
+
+// Min returns the shortest duration recorded, or zero if there are none.
+func (t *Timings) Min() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.min
+}
+
+// Percentile returns an estimate of the duration below which the given fraction of those recorded fall, where p is
+// between 0 and 1 (e.g. 0.99 for p99), as with PercentileWindow. Values of p outside that range are clamped to it. It
+// returns zero if there are none or if p is NaN.
+func (t *Timings) Percentile(p float64) time.Duration {
+	if math.IsNaN(p) {
+		return 0
+	}
+	p = min(max(p, 0), 1)
+
+	t.mu.Lock()
+	sample := make([]time.Duration, len(t.sample))
+	copy(sample, t.sample)
+	t.mu.Unlock()
+
+	if len(sample) == 0 {
+		return 0
+	}
+
+	sort.Slice(sample, func(i, j int) bool {
+		return sample[i] < sample[j]
+	})
+
+	return sample[int(p*float64(len(sample)-1)+0.5)]
+}
+
+// Total returns the sum of all durations recorded.
+func (t *Timings) Total() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.total
+}
+
+// record adds the given duration to the accumulated Timings.
+func (t *Timings) record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count++
+	t.total += d
+
+	if t.count == 1 || d < t.min {
+		t.min = d
+	}
+	if d > t.max {
+		t.max = d
+	}
+
+	if len(t.sample) < timingsSample {
+		t.sample = append(t.sample, d)
+		return
+	}
+
+	if t.rng == nil {
+		t.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if i := t.rng.Intn(t.count); i < timingsSample {
+		t.sample[i] = d
+	}
+}
+
+// Triple is a combination of three values of types A, B, and C.
+type Triple[A, B, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+// AdaptiveBatch is a processing stage that groups values of type T into slices whose size adapts to the pace of the
+// next stage, staying between minSize and maxSize. Batching begins at minSize. Whenever a full batch is taken up
+// immediately, the next stage is deemed to be keeping up and the size doubles. Whenever it has to wait, the next stage
+// is deemed to be falling behind and the size halves. The final batch may be shorter than minSize.
+func AdaptiveBatch[T any](input Pipeline[T], minSize, maxSize int) Pipeline[[]T] {
+	if minSize < 1 || maxSize < minSize {
+		return abort[T, []T](input, fmt.Errorf("AdaptiveBatch: need 1 <= minSize <= maxSize, got %d and %d", minSize, maxSize))
+	}
+
+	output := make(chan []T)
+
+	input.run(func() error {
+		defer close(output)
+
+		size := minSize
+		values := make([]T, 0, size)
+
+		for value := range input.values {
+			values = append(values, value)
+			if len(values) < size {
+				continue
+			}
+
+			select {
+			case output <- values:
+				if size *= 2; size > maxSize {
+					size = maxSize
+				}
+
+			default:
+				select {
+				case output <- values:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+
+				if size /= 2; size < minSize {
+					size = minSize
+				}
+			}
+
+			values = make([]T, 0, size)
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if len(values) > 0 {
+			select {
+			case output <- values:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[[]T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// AutoParallelMap is identical to ParallelMap except the number of concurrent mapping operations adapts to the
+// workload, staying between minW and maxW. Whenever a value arrives while every existing worker is busy, another worker
+// is started, up to maxW. Any worker beyond the first minW that then sits idle for a second stops again. Slow
+// values thereby draw in more workers while fast ones are handled by few.
+//
+// This process is not guaranteed to maintain the order of the values.
+func AutoParallelMap[I, O any](input Pipeline[I], minW, maxW int, mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	if minW < 1 || maxW < minW {
+		return abort[I, O](input, fmt.Errorf("AutoParallelMap: need 1 <= minW <= maxW, got %d and %d", minW, maxW))
+	}
+
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+		mappingGroup, mappingContext := errgroup.WithContext(input.ctx)
+
+		work := make(chan I)
+		var workers atomic.Int32
+
+		startWorker := func(elastic bool) {
+			workers.Add(1)
+
+			mappingGroup.Go(func() error {
+				var timer *time.Timer
+				var idle <-chan time.Time
+				if elastic {
+					timer = time.NewTimer(autoScaleIdle)
+					defer timer.Stop()
+					idle = timer.C
+				}
+
+				for {
+					select {
+					case value, ok := <-work:
+						if !ok {
+							return nil
+						}
+
+						newValue, err := mapper(mappingContext, value)
+						if err != nil {
+							return err
+						}
+
+						select {
+						case output <- newValue:
+						case <-mappingContext.Done():
+							return mappingContext.Err()
+						}
+
+						if elastic {
+							if !timer.Stop() {
+								<-timer.C
+							}
+							timer.Reset(autoScaleIdle)
+						}
+
+					case <-idle:
+						workers.Add(-1)
+						return nil
+					}
+				}
+			})
+		}
+
+		for i := 0; i < minW; i++ {
+			startWorker(false)
+		}
+
+		mappingGroup.Go(func() error {
+			defer close(work)
+
+			for value := range input.values {
+				select {
+				case work <- value:
+					continue
+				default:
+				}
+
+				if int(workers.Load()) < maxW {
+					startWorker(true)
+				}
+
+				select {
+				case work <- value:
+				case <-mappingContext.Done():
+					return mappingContext.Err()
+				}
+			}
+
+			return nil
+		})
+
+		return mappingGroup.Wait()
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// BatchChannelSource is a helper function around Source that generates values from the slices received on the given
+// channel, emitting the elements of each individually until the channel is closed.
+func BatchChannelSource[T any](ctx context.Context, ch <-chan []T) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		for {
+			select {
+			case batch, ok := <-ch:
+				if !ok {
+					return nil
+				}
+
+				for _, value := range batch {
+					if err := emit(value); err != nil {
+						return err
+					}
+				}
+
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+// BufferBudget is a processing stage that holds values of type T until the next stage is ready for them, limited by
+// their approximate total size rather than their number. The size of each value is estimated by the given sizeOf
+// function, and the sizes of values held are summed. Once that sum reaches the budget, no more values are taken from
+// the previous stage until enough have been passed on. The budget may be overrun by the last value taken, and a single
+// value exceeding it is still admitted when nothing else is held.
+//
+// The accounting is only as accurate as sizeOf, and excludes the overhead of the buffer itself. See BufferBudgetDrop to
+// discard values instead of waiting.
+func BufferBudget[T any](input Pipeline[T], budget int, sizeOf func(T) int) Pipeline[T] {
+	return bufferBudget(input, budget, sizeOf, false, "BufferBudget")
+}
+
+// BufferBudgetDrop is identical to BufferBudget except values that would take the sum of sizes over budget are
+// discarded, rather than the previous stage being made to wait. The previous stage is thus never held up.
+func BufferBudgetDrop[T any](input Pipeline[T], budget int, sizeOf func(T) int) Pipeline[T] {
+	return bufferBudget(input, budget, sizeOf, true, "BufferBudgetDrop")
+}
+
+// Catch is a processing stage that wraps each value of type I in a Result and, rather than letting an error upstream
+// abort the Pipeline, passes it on as a final Result carrying the error. The stream then ends normally, allowing later
+// stages to record the failure and carry on.
+//
+// To achieve this, stages after Catch run in a new errgroup of their own, derived from the Context given to the
+// Pipeline's source, while Catch itself stands in as the terminal of the stages before it. This has a few consequences:
+//
+//   - An upstream error still stops every stage before Catch, so values that were in flight at the time are lost.
+//   - Only errors arising before Catch are caught. Errors after it abort the Pipeline as usual, canceling the stages
+//     before Catch as well.
+//   - Cancellation of the source's Context is not caught, and aborts the Pipeline as usual.
+//   - Any other outputs of the upstream errgroup, such as the second of SplitStream, still see the error.
+func Catch[I any](input Pipeline[I]) Pipeline[Result[I]] {
+	group, groupContext := newStageGroup(input.group.parent)
+	output := Pipeline[Result[I]]{
+		ctx:    groupContext,
+		group:  group,
+		name:   input.name,
+		values: make(chan Result[I]),
+	}
+
+	output.run(func() error {
+		defer close(output.values)
+
+		abandon := func(err error) error {
+			input.group.cancel()
+			_ = input.wait()
+			return err
+		}
+
+	consume:
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					break consume
+				}
+
+				select {
+				case output.values <- Result[I]{Value: value}:
+				case <-groupContext.Done():
+					return abandon(groupContext.Err())
+				}
+
+			case <-input.ctx.Done():
+				break consume
+
+			case <-groupContext.Done():
+				return abandon(groupContext.Err())
+			}
+		}
+
+		err := input.wait()
+		if err == nil {
+			return nil
+		}
+
+		if groupContext.Err() != nil {
+			return groupContext.Err()
+		}
+
+		select {
+		case output.values <- Result[I]{Err: err}:
+			return nil
+		case <-groupContext.Done():
+			return groupContext.Err()
+		}
+	})
+
+	return output
+}
+
+// Changes is a processing stage that passes on a value of type T only when it differs from the one passed on before
+// it, starting with the first. This turns a stream of repeated readings, such as from polling, into one of transitions.
+func Changes[T comparable](input Pipeline[T]) Pipeline[T] {
+	return ChangesBy(input, func(_ context.Context, value T) (T, error) {
+		return value, nil
+	})
+}
+
+// ChangesBy is identical to Changes except values are compared by the key produced for each by the given function.
+func ChangesBy[T any, K comparable](input Pipeline[T], key func(context.Context, T) (K, error)) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var last K
+		first := true
+
+		for value := range input.values {
+			k, err := key(input.ctx, value)
+			if err != nil {
+				return err
+			}
+
+			if !first && k == last {
+				continue
+			}
+			first = false
+			last = k
+
+			select {
+			case output <- value:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// CircuitBreaker is a processing stage that converts values of type I into values of type O using the given mapper
+// function, guarded by a circuit breaker configured by the given settings. Values whose mapping fails are skipped,
+// counting towards tripping the breaker. Should a value arrive while the breaker is open, the Pipeline is aborted with
+// an error wrapping both ErrCircuitOpen and the most recent failure.
+func CircuitBreaker[I, O any](input Pipeline[I], settings BreakerSettings, mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	return circuitBreaker(input, settings, mapper, func(_ context.Context, _ I, err error) (O, bool, error) {
+		if errors.Is(err, ErrCircuitOpen) {
+			return *new(O), false, err
+		}
+
+		return *new(O), false, nil
+	})
+}
+
+// CircuitBreakerFallback is identical to CircuitBreaker except values whose mapping fails, and those rejected by the
+// open breaker, are passed to the given fallback function along with the failure or an error wrapping ErrCircuitOpen,
+// respectively. The value it returns is passed on in their place. Only an error from the fallback function aborts the
+// Pipeline.
+func CircuitBreakerFallback[I, O any](input Pipeline[I], settings BreakerSettings, mapper func(context.Context, I) (O, error), fallback func(context.Context, I, error) (O, error)) Pipeline[O] {
+	return circuitBreaker(input, settings, mapper, func(ctx context.Context, value I, err error) (O, bool, error) {
+		newValue, err := fallback(ctx, value, err)
+		return newValue, true, err
+	})
+}
+
+// CollectSorted is a terminal processing stage that gathers every value of type T into a slice, sorted using the given
+// less function. The whole stream is held in memory. If the Pipeline fails, the values gathered so far are returned
+// alongside the error, sorted likewise.
+func CollectSorted[T any](input Pipeline[T], less func(a, b T) bool) ([]T, error) {
+	values := make([]T, 0)
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		values = append(values, value)
+		return nil
+	})
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return less(values[i], values[j])
+	})
+
+	return values, err
+}
+
+// CollectTimed is a terminal processing stage that gathers every value of type T into a slice and reports how long the
+// Pipeline took to finish, measured from when this stage began reading. If the Pipeline fails, the values gathered so
+// far and the time taken are returned alongside the error.
+func CollectTimed[T any](input Pipeline[T]) ([]T, time.Duration, error) {
+	values := make([]T, 0)
+	start := time.Now()
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		values = append(values, value)
+		return nil
+	})
+
+	return values, time.Since(start), err
+}
+
+// CountWindow is a processing stage that, for each value of type T it receives, emits a Count of how many times that
+// value has arrived within the trailing window of time, including the current arrival. This allows bursts of a value
+// to be spotted as they happen.
+//
+// Arrivals are tallied per value in 16 buckets, each spanning a sixteenth of the window, so memory is bounded by the
+// number of distinct values seen within a window rather than by how often they arrive. In exchange, an arrival is
+// forgotten once between fifteen sixteenths of the window and the whole window has passed, depending on where in its
+// bucket it fell. Values that have not arrived within a window are discarded altogether, checked at most once per bucket.
+// The window must be positive.
+func CountWindow[T comparable](input Pipeline[T], window time.Duration) Pipeline[Count[T]] {
+	if window <= 0 {
+		return abort[T, Count[T]](input, fmt.Errorf("CountWindow: window must be positive, got %v", window))
+	}
+
+	type tally struct {
+		buckets [countWindowBuckets]int
+		last    int64
+	}
+
+	width := max(window/countWindowBuckets, 1)
+	start := time.Now()
+	tallies := make(map[T]*tally)
+	var swept int64
+
+	return Map(input, func(_ context.Context, value T) (Count[T], error) {
+		now := int64(time.Since(start) / width)
+
+		if now > swept {
+			for v, t := range tallies {
+				if now-t.last >= countWindowBuckets {
+					delete(tallies, v)
+				}
+			}
+			swept = now
+		}
+
+		t, ok := tallies[value]
+		if !ok {
+			t = &tally{last: now}
+			tallies[value] = t
+		}
+
+		if now-t.last >= countWindowBuckets {
+			t.buckets = [countWindowBuckets]int{}
+		} else {
+			for b := t.last + 1; b <= now; b++ {
+				t.buckets[b%countWindowBuckets] = 0
+			}
+		}
+		t.last = now
+		t.buckets[now%countWindowBuckets]++
+
+		n := 0
+		for _, count := range t.buckets {
+			n += count
+		}
+
+		return Count[T]{Value: value, N: n}, nil
+	})
+}
+
+// DelimitedSource is a helper function around Source that generates records from the given reader, each ending with
+// the given delimiter byte, such as NUL for the output of find -print0. Records are emitted without the delimiter. A
+// final record lacking one is emitted as well, provided it is not empty. Cancellation is checked between records, but a
+// read already blocked on the reader cannot be interrupted except by the reader itself.
+func DelimitedSource(ctx context.Context, r io.Reader, delim byte) Pipeline[[]byte] {
+	return Source(ctx, func(ctx context.Context, emit func([]byte) error) error {
+		reader := bufio.NewReader(r)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			record, readErr := reader.ReadBytes(delim)
+			if readErr == nil {
+				record = record[:len(record)-1]
+			} else if readErr != io.EOF {
+				return readErr
+			} else if len(record) == 0 {
+				return nil
+			}
+
+			if err := emit(record); err != nil {
+				return err
+			} else if readErr == io.EOF {
+				return nil
+			}
+		}
+	})
+}
+
+// Delta is a processing stage that passes on the difference between each number of type T and the one before it. As the
+// first has no predecessor, nothing is passed on for it, so the output is one value shorter than the input. This turns
+// running totals, such as a count of bytes transferred so far, into amounts per interval.
+func Delta[T Number](input Pipeline[T]) Pipeline[T] {
+	return DeltaBy(input, func(current, previous T) T {
+		return current - previous
+	})
+}
+
+// DeltaBy is identical to Delta except the difference is computed by the given sub function, which receives each value
+// followed by the one before it.
+func DeltaBy[T any](input Pipeline[T], sub func(current, previous T) T) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var previous T
+		first := true
+
+		for value := range input.values {
+			if first {
+				previous = value
+				first = false
+				continue
+			}
+
+			delta := sub(value, previous)
+			previous = value
+
+			select {
+			case output <- delta:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Deref is a processing stage that converts pointers to values of type T into the values they point to. Nil pointers
+// are silently dropped.
+func Deref[T any](input Pipeline[*T]) Pipeline[T] {
+	return deref(input, nil)
+}
+
+// DerefStrict is identical to Deref except a nil pointer aborts the Pipeline with ErrNilPointer.
+func DerefStrict[T any](input Pipeline[*T]) Pipeline[T] {
+	return deref(input, ErrNilPointer)
+}
+
+// DirWatchSource is a helper function around Source that generates the paths of files appearing in the given
+// directory, checking for them at the given polling interval until the Context is canceled. Files already present at
+// the start are emitted first. Each file is emitted once, unless it is removed and later reappears. Subdirectories are
+// ignored. Note a file is emitted as soon as it is seen, which may be before whoever created it has finished writing.
+// The polling interval must be positive.
+func DirWatchSource(ctx context.Context, dir string, poll time.Duration) Pipeline[string] {
+	if poll <= 0 {
+		return abort[string, string](SliceSource[string](ctx, nil), fmt.Errorf("DirWatchSource: poll must be positive, got %v", poll))
+	}
+
+	return Source(ctx, func(ctx context.Context, emit func(string) error) error {
+		seen := make(map[string]bool)
+
+		for {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+
+			present := make(map[string]bool, len(entries))
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+
+				name := entry.Name()
+				present[name] = true
+
+				if !seen[name] {
+					if err := emit(filepath.Join(dir, name)); err != nil {
+						return err
+					}
+				}
+			}
+			seen = present
+
+			if err := sleep(ctx, poll); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// DistinctApprox is a processing stage that passes on values of type T only the first time their hash, as produced by
+// the given function, is seen. Rather than remembering every hash, it uses a Bloom filter sized for expectedN distinct
+// values with the given false-positive rate, so memory use is fixed. The trade-off is that a value never seen before is
+// occasionally mistaken for a duplicate and dropped, at roughly fpRate while no more than expectedN distinct values
+// have passed, and increasingly often thereafter. Duplicates themselves are never passed on.
+func DistinctApprox[T any](input Pipeline[T], hash func(T) uint64, expectedN int, fpRate float64) Pipeline[T] {
+	if expectedN < 1 || fpRate <= 0 || fpRate >= 1 {
+		return abort[T, T](input, fmt.Errorf("DistinctApprox: need expectedN >= 1 and 0 < fpRate < 1, got %d and %v", expectedN, fpRate))
+	}
+
+	filter := newBloomFilter(expectedN, fpRate)
+
+	return Filter(input, func(_ context.Context, value T) (bool, error) {
+		return filter.add(hash(value)), nil
+	})
+}
+
+// DrainN is a terminal processing stage that reads and discards up to the first n values of type T. Once n values have
+// been consumed, the rest of the Pipeline is cancelled. The number actually consumed is returned, which is less than n
+// if fewer values are produced.
+func DrainN[T any](input Pipeline[T], n int) (int, error) {
+	var consumed int
+
+	input.run(func() error {
+		for consumed < n {
+			select {
+			case _, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+				consumed++
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return errStopped
+	})
+
+	err := input.wait()
+	return consumed, err
+}
+
+// DropInvalid is identical to Filter except each value failing the given valid function is first passed to the onDrop
+// function, such as to log it. An invalid value is merely dropped, while an error from the valid function itself still
+// fails the Pipeline.
+func DropInvalid[T any](input Pipeline[T], valid func(context.Context, T) (bool, error), onDrop func(T)) Pipeline[T] {
+	return Filter(input, func(ctx context.Context, value T) (bool, error) {
+		ok, err := valid(ctx, value)
+		if err == nil && !ok {
+			onDrop(value)
+		}
+
+		return ok, err
+	})
+}
+
+// ExpandRange is a processing stage that expands each value of type I into any number of values of type O. The given
+// expand function passes each value it generates to the emit function it is given, so no slice of them need be built.
+// Once the Pipeline is cancelled, emit returns an error, which expand should return promptly.
+func ExpandRange[I, O any](input Pipeline[I], expand func(context.Context, I, func(O) error) error) Pipeline[O] {
+	output := make(chan O)
+
+	emit := func(value O) error {
+		select {
+		case output <- value:
+			return nil
+		case <-input.ctx.Done():
+			return input.ctx.Err()
+		}
+	}
+
+	input.run(func() error {
+		defer close(output)
+
+		for value := range input.values {
+			if err := expand(input.ctx, value, emit); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// ExponentialSmooth is a processing stage that passes on an exponentially weighted moving average of a stream of
+// numbers. The first number is passed on unchanged, and each later one moves the average by the fraction alpha of its
+// difference from it. Smaller values of alpha therefore give a smoother but slower-moving result, while an alpha of 1
+// passes numbers on unchanged.
+func ExponentialSmooth[T ~float64](input Pipeline[T], alpha float64) Pipeline[float64] {
+	if alpha <= 0 || alpha > 1 {
+		return abort[T, float64](input, fmt.Errorf("ExponentialSmooth: need 0 < alpha <= 1, got %v", alpha))
+	}
+
+	var average float64
+	first := true
+
+	return Map(input, func(_ context.Context, value T) (float64, error) {
+		if first {
+			average = float64(value)
+			first = false
+		} else {
+			average += alpha * (float64(value) - average)
+		}
+
+		return average, nil
+	})
+}
+
+// FileSink is a terminal processing stage that writes each value of type T to its own file within the given directory,
+// using the given write function. Each file is named by the name function, and the directory is created, along with
+// any missing parents, upon the first value. Should two values share a name, the later one overwrites the file of the
+// earlier one. If writing a value fails, its partial file is removed and the Pipeline aborted. So is it if a name would
+// place the file outside the directory, such as one containing "..".
+func FileSink[T any](input Pipeline[T], dir string, name func(T) string, write func(io.Writer, T) error) error {
+	created := false
+
+	return Sink(input, func(_ context.Context, value T) error {
+		if !created {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			created = true
+		}
+
+		base := name(value)
+		path := filepath.Join(dir, base)
+		if rel, err := filepath.Rel(filepath.Clean(dir), path); err != nil || rel == "." || !filepath.IsLocal(rel) {
+			return fmt.Errorf("FileSink: name %q leaves the directory", base)
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		err = write(file, value)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.Remove(path)
+		}
+		return err
+	})
+}
+
 // Filter is a processing stage that passes or blocks values of type T according to whether
 // the given filter function returns true or false, respectively.
 func Filter[T any](input Pipeline[T], filter func(context.Context, T) (bool, error)) Pipeline[T] {
 	output := make(chan T)
 
-	input.group.Go(func() error {
+	input.run(func() error {
+		defer close(output)
+
+		for value := range input.values {
+			pass, err := filter(input.ctx, value)
+			if err != nil {
+				return err
+			} else if pass {
+				select {
+				case output <- value:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// FindGaps is a processing stage that checks values of type T for missing sequence numbers, as given by seqOf, passing
+// on a Gap for each run of numbers skipped between consecutive values. Input is expected in ascending order of sequence.
+// A duplicate or a number lower than the highest seen so far is not considered a gap and is otherwise ignored, so a
+// value arriving late does not retract a Gap already reported for it.
+func FindGaps[T any](input Pipeline[T], seqOf func(T) int64) Pipeline[Gap] {
+	output := make(chan Gap)
+
+	input.run(func() error {
+		defer close(output)
+
+		var highest int64
+		first := true
+
+		for value := range input.values {
+			seq := seqOf(value)
+
+			if first {
+				highest = seq
+				first = false
+				continue
+			} else if seq <= highest {
+				continue
+			}
+
+			gap := Gap{From: highest + 1, To: seq - 1}
+			highest = seq
+
+			if gap.From > gap.To {
+				continue
+			}
+
+			select {
+			case output <- gap:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[Gap]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// FlatMapParallel is a processing stage that converts each value of type I into a slice of values of type O using the
+// given mapper function, running up to the given number of mapping operations at once, and passes on the resulting
+// values individually. It is equivalent to ParallelMap followed by Flatten, but with bounded concurrency.
+//
+// This process is not guaranteed to maintain the order of the values, either across or within slices.
+func FlatMapParallel[I, O any](input Pipeline[I], workers int, mapper func(context.Context, I) ([]O, error)) Pipeline[O] {
+	if workers < 1 {
+		return abort[I, O](input, fmt.Errorf("FlatMapParallel: workers must be at least 1, got %d", workers))
+	}
+
+	return pool(input, workers, func(ctx context.Context, value I, emit func(O) error) error {
+		newValues, err := mapper(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		for _, newValue := range newValues {
+			if err := emit(newValue); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Flatten is a processing stage that collapses a sequence of slices of type T into a single slice of the same type.
+func Flatten[T any](input Pipeline[[]T]) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		for slice := range input.values {
+			for _, value := range slice {
+				select {
+				case output <- value:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// FlattenN is a processing stage that collapses a sequence of slices of slices of type T into a single slice of the
+// same type, equivalent to applying Flatten twice but without the intermediate stage.
+func FlattenN[T any](input Pipeline[[][]T]) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		for slices := range input.values {
+			for _, slice := range slices {
+				for _, value := range slice {
+					select {
+					case output <- value:
+					case <-input.ctx.Done():
+						return input.ctx.Err()
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// FlattenWithMarkers is identical to Flatten except each value is wrapped in a FlatItem marking the boundaries of the
+// slices it came from, and empty slices produce a marker of their own instead of vanishing.
+func FlattenWithMarkers[T any](input Pipeline[[]T]) Pipeline[FlatItem[T]] {
+	output := make(chan FlatItem[T])
+
+	input.run(func() error {
+		defer close(output)
+
+		emit := func(item FlatItem[T]) error {
+			select {
+			case output <- item:
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for slice := range input.values {
+			if len(slice) == 0 {
+				if err := emit(FlatItem[T]{Last: true, Empty: true}); err != nil {
+					return err
+				}
+			}
+
+			for i, value := range slice {
+				if err := emit(FlatItem[T]{Value: value, Last: i == len(slice)-1}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[FlatItem[T]]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// FrameBytes is a processing stage that groups a stream of bytes into frames of frameSize, each beginning with the last
+// overlap bytes of the frame before it. Frames therefore advance by frameSize-overlap bytes at a time. Should the stream
+// end partway through a frame, that frame is passed on short rather than padded, provided it holds at least one byte
+// not already passed on in an earlier frame.
+func FrameBytes(input Pipeline[byte], frameSize, overlap int) Pipeline[[]byte] {
+	if overlap < 0 || frameSize <= overlap {
+		return abort[byte, []byte](input, fmt.Errorf("FrameBytes: need 0 <= overlap < frameSize, got %d and %d", overlap, frameSize))
+	}
+
+	output := make(chan []byte)
+
+	input.run(func() error {
+		defer close(output)
+
+		frame := make([]byte, 0, frameSize)
+		var fresh int
+
+		flush := func() error {
+			select {
+			case output <- frame:
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for b := range input.values {
+			frame = append(frame, b)
+			fresh++
+
+			if len(frame) == frameSize {
+				if err := flush(); err != nil {
+					return err
+				}
+
+				next := make([]byte, overlap, frameSize)
+				copy(next, frame[frameSize-overlap:])
+				frame = next
+				fresh = 0
+			}
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if fresh > 0 {
+			return flush()
+		}
+
+		return nil
+	})
+
+	return Pipeline[[]byte]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// GobSink is a terminal processing stage that writes values of type T to the given writer using encoding/gob, such that
+// a GobSource can read them back. The writer is not closed.
+func GobSink[T any](input Pipeline[T], w io.Writer) error {
+	encoder := gob.NewEncoder(w)
+
+	return Sink(input, func(_ context.Context, value T) error {
+		return encoder.Encode(value)
+	})
+}
+
+// GobSource is a helper function around Source that generates values of type T by decoding them from the given reader
+// using encoding/gob until it reaches EOF, as written by GobSink. Cancellation is checked between values, but a read
+// already blocked on the reader cannot be interrupted except by the reader itself, such as by closing it or setting a
+// deadline.
+func GobSource[T any](ctx context.Context, r io.Reader) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		decoder := gob.NewDecoder(r)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var value T
+			if err := decoder.Decode(&value); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if err := emit(value); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Heartbeat is a processing stage that passes values of type T through unchanged while calling the given beat function
+// whenever no value has arrived for the given interval. During a long lull the beat function is called once per
+// interval, until either a value arrives or the input is exhausted. The interval must be positive.
+func Heartbeat[T any](input Pipeline[T], interval time.Duration, beat func()) Pipeline[T] {
+	if interval <= 0 {
+		return abort[T, T](input, fmt.Errorf("Heartbeat: interval must be positive, got %v", interval))
+	}
+
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+
+				select {
+				case output <- value:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(interval)
+
+			case <-timer.C:
+				beat()
+				timer.Reset(interval)
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// IdleTimeout is a processing stage that passes values of type T through unchanged, but fails the Pipeline with
+// ErrIdleTimeout if no value arrives for the given duration. The timer restarts after each value is passed on, so
+// time spent waiting on later stages is not counted. Unlike WithDeadline, this detects a stalled input rather than
+// limiting the Pipeline as a whole.
+func IdleTimeout[T any](input Pipeline[T], d time.Duration) Pipeline[T] {
+	if d <= 0 {
+		return abort[T, T](input, fmt.Errorf("IdleTimeout: d must be positive, got %v", d))
+	}
+
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+
+				timer.Stop()
+
+				select {
+				case output <- value:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+
+				select {
+				case <-timer.C:
+				default:
+				}
+				timer.Reset(d)
+
+			case <-timer.C:
+				return ErrIdleTimeout
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// IndexOf is a terminal processing stage that returns the zero-based position of the first value of type T satisfying
+// the given predicate function, or -1 if none does. Once a match is found, the rest of the Pipeline is cancelled.
+func IndexOf[T any](input Pipeline[T], pred func(context.Context, T) (bool, error)) (int, error) {
+	index := -1
+	var seen int
+
+	err := Sink(input, func(ctx context.Context, value T) error {
+		match, err := pred(ctx, value)
+		if err != nil {
+			return err
+		} else if match {
+			index = seen
+			return errStopped
+		}
+
+		seen++
+		return nil
+	})
+
+	if err != nil {
+		return -1, err
+	}
+	return index, nil
+}
+
+// InterleaveRatio is a processing stage that combines the values of two pipelines of type T by passing on pRatio values
+// from primary, then sRatio values from secondary, and repeating. The order is strict: while it is primary's turn, a
+// value ready on secondary waits, and vice versa. Once either input is exhausted, the remaining values of the other
+// are passed on as they arrive.
+//
+// Inputs originating from different sources have their errgroups tied together so that a failure or cancellation in
+// either one aborts the other as well.
+func InterleaveRatio[T any](primary, secondary Pipeline[T], pRatio, sRatio int) Pipeline[T] {
+	attach(primary, secondary)
+
+	if pRatio < 1 || sRatio < 1 {
+		return abort[T, T](primary, fmt.Errorf("InterleaveRatio: ratios must be at least 1, got %d and %d", pRatio, sRatio))
+	}
+
+	output := make(chan T)
+
+	primary.run(func() error {
+		defer close(output)
+
+		inputs := []chan T{primary.values, secondary.values}
+		ratios := []int{pRatio, sRatio}
+
+		for inputs[0] != nil || inputs[1] != nil {
+			for i := range inputs {
+				for n := 0; n < ratios[i] && inputs[i] != nil; n++ {
+					select {
+					case value, ok := <-inputs[i]:
+						if !ok {
+							inputs[i] = nil
+							continue
+						}
+
+						select {
+						case output <- value:
+						case <-primary.ctx.Done():
+							return primary.ctx.Err()
+						}
+
+					case <-primary.ctx.Done():
+						return primary.ctx.Err()
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    primary.ctx,
+		group:  primary.group,
+		name:   primary.name,
+		values: output,
+	}
+}
+
+// Intersperse is a processing stage that passes on values of type T with the given separator inserted between each
+// consecutive pair. No separator precedes the first value or follows the last, so an empty or single-valued stream
+// passes through unchanged.
+func Intersperse[T any](input Pipeline[T], sep T) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		first := true
+
+		for value := range input.values {
+			if !first {
+				select {
+				case output <- sep:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			}
+			first = false
+
+			select {
+			case output <- value:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Iterate is a processing stage that applies the given function to each value of type T n times over, each time to the
+// result of the last, and passes on the final result. Where n is zero, values pass through unchanged.
+func Iterate[T any](input Pipeline[T], n int, fn func(context.Context, T) (T, error)) Pipeline[T] {
+	if n < 0 {
+		return abort[T, T](input, fmt.Errorf("Iterate: n must not be negative, got %d", n))
+	}
+
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		for i := 0; i < n; i++ {
+			var err error
+			if value, err = fn(ctx, value); err != nil {
+				return value, err
+			}
+		}
+
+		return value, nil
+	})
+}
+
+// IteratorSource is a helper function around Source that generates values from the given Iterator until it is
+// exhausted. The Iterator is always closed afterwards, even if the Pipeline is aborted, and any error from doing so is
+// joined with the error that ended the iteration, if any.
+func IteratorSource[T any](ctx context.Context, it Iterator[T]) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) (err error) {
+		defer func() {
+			err = errors.Join(err, it.Close())
+		}()
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			value, ok, err := it.Next()
+			if err != nil {
+				return err
+			} else if !ok {
+				return nil
+			}
+
+			if err := emit(value); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Join is a processing stage performing an inner equi-join of two pipelines. Each value from left is paired with every
+// value from right sharing its key, as produced by the given keyA and keyB functions, respectively. A left value with
+// several matches yields several Pairs, ordered as the matches arrived from right.
+//
+// Because neither input can be searched, right is consumed entirely and held in memory, indexed by key, before any
+// value is taken from left. Pass the smaller of the two streams as right. Left is then processed as it arrives.
+func Join[A, B any, K comparable](left Pipeline[A], right Pipeline[B], keyA func(A) K, keyB func(B) K) Pipeline[Pair[A, B]] {
+	output := make(chan Pair[A, B])
+
+	attach(left, right)
+
+	left.run(func() error {
+		defer close(output)
+
+		index := make(map[K][]B)
+		for value := range right.values {
+			key := keyB(value)
+			index[key] = append(index[key], value)
+		}
+
+		if err := left.ctx.Err(); err != nil {
+			return err
+		}
+
+		for value := range left.values {
+			for _, match := range index[keyA(value)] {
+				select {
+				case output <- Pair[A, B]{First: value, Second: match}:
+				case <-left.ctx.Done():
+					return left.ctx.Err()
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[Pair[A, B]]{
+		ctx:    left.ctx,
+		group:  left.group,
+		name:   left.name,
+		values: output,
+	}
+}
+
+// LatestByKey is a terminal processing stage that consumes values of type T and returns the most recent one seen for
+// each key produced by the given key function. This is useful for building a snapshot of current state from a log of
+// events.
+//
+// Every distinct key is held in memory, along with its latest value, until the stream ends.
+func LatestByKey[T any, K comparable](input Pipeline[T], key func(context.Context, T) (K, error)) (map[K]T, error) {
+	return ToMap(input, key, func(_ context.Context, value T) (T, error) {
+		return value, nil
+	})
+}
+
+// Map is a processing stage that converts values of type I into values of type O using the given mapper function.
+func Map[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+
+		for value := range input.values {
+			newValue, err := mapper(input.ctx, value)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case output <- newValue:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// MapFirst is a processing stage that converts only the first value of type T using the given mapper function, such as
+// to parse a header row differently from the records that follow, passing all others on unchanged. An error from the
+// mapper aborts the Pipeline.
+func MapFirst[T any](input Pipeline[T], mapper func(context.Context, T) (T, error)) Pipeline[T] {
+	first := true
+
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		if !first {
+			return value, nil
+		}
+
+		first = false
+		return mapper(ctx, value)
+	})
+}
+
+// MapLast is a processing stage that converts only the last value of type T using the given mapper function, passing
+// all others on unchanged. As the last value cannot be recognized until the input is exhausted, each value is held back
+// until the next arrives, delaying the stream by one. An error from the mapper aborts the Pipeline. Should the Pipeline
+// abort upstream, the value being held is not passed on.
+func MapLast[T any](input Pipeline[T], mapper func(context.Context, T) (T, error)) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var held T
+		holding := false
+
+		for value := range input.values {
+			if holding {
+				select {
+				case output <- held:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			}
+
+			held, holding = value, true
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if !holding {
+			return nil
+		}
+
+		last, err := mapper(input.ctx, held)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case output <- last:
+			return nil
+		case <-input.ctx.Done():
+			return input.ctx.Err()
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// MapOrElse is identical to Map except a value the mapper function fails to convert is passed, along with the error, to
+// the given fallback function, which may supply a replacement, such as a cached or default value. Only an error from
+// the fallback aborts the Pipeline.
+func MapOrElse[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error), fallback func(context.Context, I, error) (O, error)) Pipeline[O] {
+	return Map(input, func(ctx context.Context, value I) (O, error) {
+		newValue, err := mapper(ctx, value)
+		if err != nil {
+			return fallback(ctx, value, err)
+		}
+
+		return newValue, nil
+	})
+}
+
+// MapPhased is a processing stage that converts values of type I into values of type O in two phases, each with its own
+// degree of parallelism. The first converts values to type M using ioStage across ioWorkers, suiting work that mostly
+// waits, such as on the network. The second converts those to type O using cpuStage across cpuWorkers, suiting work that
+// keeps a processor busy. If cpuWorkers is zero, it defaults to runtime.GOMAXPROCS(0). A buffer of ioWorkers values
+// between the phases lets the first carry on while the second is busy. An error in either phase aborts the Pipeline.
+//
+// This process is not guaranteed to maintain the order of the values.
+func MapPhased[I, M, O any](input Pipeline[I], ioWorkers int, ioStage func(context.Context, I) (M, error), cpuWorkers int, cpuStage func(context.Context, M) (O, error)) Pipeline[O] {
+	if cpuWorkers == 0 {
+		cpuWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	if ioWorkers < 1 || cpuWorkers < 1 {
+		return abort[I, O](input, fmt.Errorf("MapPhased: workers must be at least 1, got %d and %d", ioWorkers, cpuWorkers))
+	}
+
+	intermediate := pool(input, ioWorkers, func(ctx context.Context, value I, emit func(M) error) error {
+		newValue, err := ioStage(ctx, value)
+		if err != nil {
+			return err
+		}
+		return emit(newValue)
+	})
+
+	buffered := make(chan M, ioWorkers)
+
+	intermediate.run(func() error {
+		defer close(buffered)
+
+		for value := range intermediate.values {
+			select {
+			case buffered <- value:
+			case <-intermediate.ctx.Done():
+				return intermediate.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	bufferedIntermediate := Pipeline[M]{
+		ctx:    intermediate.ctx,
+		group:  intermediate.group,
+		name:   intermediate.name,
+		values: buffered,
+	}
+
+	return pool(bufferedIntermediate, cpuWorkers, func(ctx context.Context, value M, emit func(O) error) error {
+		newValue, err := cpuStage(ctx, value)
+		if err != nil {
+			return err
+		}
+		return emit(newValue)
+	})
+}
+
+// MapSafe is identical to Map except a panic in the mapper function is recovered and converted into a *PanicError,
+// which fails the Pipeline like any other error instead of crashing the program.
+func MapSafe[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	return mapSafe(input, mapper, func(_ I, err *PanicError) error {
+		return err
+	})
+}
+
+// MapSafeSkip is identical to MapSafe except a value whose mapping panics is skipped rather than failing the Pipeline.
+// The recovered *PanicError is passed to the given onPanic function, if not nil, along with the offending value.
+// Errors returned normally by the mapper function still fail the Pipeline.
+func MapSafeSkip[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error), onPanic func(context.Context, I, *PanicError)) Pipeline[O] {
+	return mapSafe(input, mapper, func(value I, err *PanicError) error {
+		if onPanic != nil {
+			onPanic(input.ctx, value, err)
+		}
+
+		return nil
+	})
+}
+
+// MapSlice is a processing stage that converts slices of type I into slices of type O using the given function. Each
+// slice is handled as a single unit, so the batch structure of the stream is preserved, unlike with Flatten.
+func MapSlice[I, O any](input Pipeline[[]I], fn func(context.Context, []I) ([]O, error)) Pipeline[[]O] {
+	return Map(input, fn)
+}
+
+// MapTimed is identical to Map except the duration of each call to the mapper function, whether or not it succeeds, is
+// recorded in the returned Timings. Once the Pipeline has finished, these may be read for a report on latency.
+func MapTimed[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) (Pipeline[O], *Timings) {
+	timings := &Timings{}
+
+	return Map(input, func(ctx context.Context, value I) (O, error) {
+		start := time.Now()
+		defer func() {
+			timings.record(time.Since(start))
+		}()
+
+		return mapper(ctx, value)
+	}), timings
+}
+
+// MapWhere is a processing stage that converts values of type T satisfying the given predicate function using the given
+// mapper function, passing all others on unchanged. As values of both kinds share the output, the mapper cannot change
+// their type.
+func MapWhere[T any](input Pipeline[T], pred func(context.Context, T) (bool, error), mapper func(context.Context, T) (T, error)) Pipeline[T] {
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		match, err := pred(ctx, value)
+		if err != nil || !match {
+			return value, err
+		}
+
+		return mapper(ctx, value)
+	})
+}
+
+// MapWithResource is identical to ParallelMap except each of the given number of workers has a resource of type R for
+// the mapper function to use, such as a database connection. A worker's resource is created using the newResource
+// function upon its first value, reused for each value thereafter, and finally passed to the release function once the
+// worker stops, whether because the input is exhausted or the Pipeline aborted. At most one resource per worker ever
+// exists.
+//
+// This process is not guaranteed to maintain the order of the values.
+func MapWithResource[I, O, R any](input Pipeline[I], newResource func(context.Context) (R, error), mapper func(context.Context, R, I) (O, error), release func(R), workers int) Pipeline[O] {
+	if workers < 1 {
+		return abort[I, O](input, fmt.Errorf("MapWithResource: workers must be at least 1, got %d", workers))
+	}
+
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+		workerGroup, workerContext := errgroup.WithContext(input.ctx)
+
+		for i := 0; i < workers; i++ {
+			workerGroup.Go(func() error {
+				var resource R
+				created := false
+
+				defer func() {
+					if created {
+						release(resource)
+					}
+				}()
+
+				for {
+					select {
+					case value, ok := <-input.values:
+						if !ok {
+							return nil
+						}
+
+						if !created {
+							var err error
+							if resource, err = newResource(workerContext); err != nil {
+								return err
+							}
+							created = true
+						}
+
+						newValue, err := mapper(workerContext, resource, value)
+						if err != nil {
+							return err
+						}
+
+						select {
+						case output <- newValue:
+						case <-workerContext.Done():
+							return workerContext.Err()
+						}
+
+					case <-workerContext.Done():
+						return workerContext.Err()
+					}
+				}
+			})
+		}
+
+		return workerGroup.Wait()
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// MapWithRetryer is identical to Map except a failed call to the mapper function is retried for as long as the given
+// Retryer allows, waiting between attempts as it directs. Once the Retryer gives up, the Pipeline is aborted with the
+// last error returned by the mapper. Waiting is cut short if the Pipeline is aborted.
+func MapWithRetryer[I, O any](input Pipeline[I], r Retryer, mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	return Map(input, func(ctx context.Context, value I) (O, error) {
+		for attempt := 1; ; attempt++ {
+			newValue, err := mapper(ctx, value)
+			if err == nil {
+				return newValue, nil
+			}
+
+			delay, retry := r.Next(attempt, err)
+			if !retry {
+				return newValue, err
+			}
+
+			if err := sleep(ctx, delay); err != nil {
+				return newValue, err
+			}
+		}
+	})
+}
+
+// MergeFair is a processing stage that combines the values of several pipelines of type T into one. Whenever more
+// than one input has a value ready, the inputs are served in round-robin order, so an input with a value waiting is
+// never passed over more than len(inputs)-1 times, no matter how busy the others are. The output is closed once every
+// input has been drained.
+//
+// Inputs originating from different sources have their errgroups tied together so that a failure or cancellation in
+// any one of them aborts the others as well.
+func MergeFair[T any](first Pipeline[T], rest ...Pipeline[T]) Pipeline[T] {
+	base := first
+	inputs := append([]Pipeline[T]{first}, rest...)
+	output := make(chan T)
+
+	for _, input := range rest {
+		attach(base, input)
+	}
+
+	base.run(func() error {
+		defer close(output)
+
+		active := make([]chan T, len(inputs))
+		for i, input := range inputs {
+			active[i] = input.values
+		}
+
+		next := 0
+		for len(active) > 0 {
+			chosen, value, ok := -1, *new(T), false
+
+			for offset := 0; offset < len(active) && chosen < 0; offset++ {
+				i := (next + offset) % len(active)
+				select {
+				case value, ok = <-active[i]:
+					chosen = i
+				default:
+				}
+			}
+
+			if chosen < 0 {
+				cases := make([]reflect.SelectCase, len(active)+1)
+				cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(base.ctx.Done())}
+				for i, values := range active {
+					cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(values)}
+				}
+
+				index, received, receivedOK := reflect.Select(cases)
+				if index == 0 {
+					return base.ctx.Err()
+				}
+
+				chosen, ok = index-1, receivedOK
+				if ok {
+					value, _ = received.Interface().(T)
+				}
+			}
+
+			if !ok {
+				active = append(active[:chosen], active[chosen+1:]...)
+				next = chosen
+				continue
+			}
+
+			select {
+			case output <- value:
+				next = chosen + 1
+			case <-base.ctx.Done():
+				return base.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    base.ctx,
+		group:  base.group,
+		name:   base.name,
+		values: output,
+	}
+}
+
+// MergeSorted is a processing stage that combines several pipelines of type T, each already sorted according to the
+// given less function, into one sorted stream. The head of every input is awaited before the least of them is passed
+// on, so a slow input holds back the rest rather than being overtaken. Values comparing equal are taken from the
+// earlier input first. The output is closed once every input has been drained.
+//
+// At least one input must be given. Inputs originating from different sources have their errgroups tied together so
+// that a failure or cancellation in any one of them aborts the others as well.
+func MergeSorted[T any](less func(a, b T) bool, inputs ...Pipeline[T]) Pipeline[T] {
+	base := inputs[0]
+	output := make(chan T)
+
+	for _, input := range inputs[1:] {
+		attach(base, input)
+	}
+
+	base.run(func() error {
+		defer close(output)
+
+		heads := &heapOf[mergeCursor[T]]{less: func(a, b mergeCursor[T]) bool {
+			if less(a.value, b.value) {
+				return true
+			} else if less(b.value, a.value) {
+				return false
+			}
+
+			return a.source < b.source
+		}}
+
+		advance := func(source int) error {
+			select {
+			case value, ok := <-inputs[source].values:
+				if ok {
+					heap.Push(heads, mergeCursor[T]{value: value, source: source})
+				}
+				return nil
+			case <-base.ctx.Done():
+				return base.ctx.Err()
+			}
+		}
+
+		for source := range inputs {
+			if err := advance(source); err != nil {
+				return err
+			}
+		}
+
+		for heads.Len() > 0 {
+			head := heap.Pop(heads).(mergeCursor[T])
+
+			select {
+			case output <- head.value:
+			case <-base.ctx.Done():
+				return base.ctx.Err()
+			}
+
+			if err := advance(head.source); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    base.ctx,
+		group:  base.group,
+		name:   base.name,
+		values: output,
+	}
+}
+
+// MergeTagged is identical to MergeFair except each value is wrapped in a Tagged recording the index of the input it
+// came from.
+func MergeTagged[T any](first Pipeline[T], rest ...Pipeline[T]) Pipeline[Tagged[T]] {
+	inputs := append([]Pipeline[T]{first}, rest...)
+	tagged := make([]Pipeline[Tagged[T]], len(inputs))
+
+	for i, input := range inputs {
+		i := i
+		tagged[i] = Map(input, func(_ context.Context, value T) (Tagged[T], error) {
+			return Tagged[T]{Source: i, Value: value}, nil
+		})
+	}
+
+	return MergeFair(tagged[0], tagged[1:]...)
+}
+
+// Monotonic is a processing stage that ensures values of type T are passed on in non-decreasing order of the keys given
+// by the key function, such as event timestamps. Equal keys are permitted. A value whose key is less than that of the
+// last value passed on is handled according to the given mode: in DropMode it is dropped and the stream continues,
+// while in ErrorMode the Pipeline is aborted with ErrOutOfOrder.
+func Monotonic[T any](input Pipeline[T], key func(T) int64, mode MonotonicMode) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var last int64
+		first := true
+
+		for value := range input.values {
+			k := key(value)
+
+			if !first && k < last {
+				if mode == ErrorMode {
+					return fmt.Errorf("%w: key %d after %d", ErrOutOfOrder, k, last)
+				}
+				continue
+			}
+
+			last, first = k, false
+
+			select {
+			case output <- value:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// MovingMedian is a processing stage that passes on, after each number of type T, the median of the trailing window of
+// values including it, which unlike a moving average is barely moved by occasional spikes. Output begins with the first
+// value, so until the window fills the median is of however many values have arrived. An even number of values yields
+// the mean of the middle two. A NaN aborts the Pipeline with ErrNaN.
+//
+// The window is split between two heaps, one holding the lesser half of its values and the other the greater, so the
+// median is always found at the top of one or both. Each value entering or leaving the window therefore takes time
+// proportional to the logarithm of the window, while memory use is proportional to the window itself.
+func MovingMedian[T ~float64](input Pipeline[T], window int) Pipeline[float64] {
+	if window < 1 {
+		return abort[T, float64](input, fmt.Errorf("MovingMedian: window must be at least 1, got %d", window))
+	}
+
+	output := make(chan float64)
+
+	input.run(func() error {
+		defer close(output)
+
+		lower := &medianHeap[T]{less: func(a, b T) bool { return a > b }}
+		upper := &medianHeap[T]{less: func(a, b T) bool { return a < b }}
+
+		ring := make([]*medianNode[T], 0, window)
+		var oldest int
+
+		for value := range input.values {
+			if math.IsNaN(float64(value)) {
+				return ErrNaN
+			}
+
+			node := &medianNode[T]{value: value}
+			if len(ring) < window {
+				ring = append(ring, node)
+			} else {
+				if expired := ring[oldest]; expired.lower {
+					heap.Remove(lower, expired.index)
+				} else {
+					heap.Remove(upper, expired.index)
+				}
+
+				ring[oldest] = node
+				oldest = (oldest + 1) % window
+			}
+
+			if lower.Len() == 0 || value <= lower.nodes[0].value {
+				node.lower = true
+				heap.Push(lower, node)
+			} else {
+				heap.Push(upper, node)
+			}
+
+			// Keep the halves balanced, with the lesser holding the extra value when their total is odd.
+			if lower.Len() > upper.Len()+1 {
+				moved := heap.Pop(lower).(*medianNode[T])
+				moved.lower = false
+				heap.Push(upper, moved)
+			} else if upper.Len() > lower.Len() {
+				moved := heap.Pop(upper).(*medianNode[T])
+				moved.lower = true
+				heap.Push(lower, moved)
+			}
+
+			median := float64(lower.nodes[0].value)
+			if lower.Len() == upper.Len() {
+				median = (median + float64(upper.nodes[0].value)) / 2
+			}
+
+			select {
+			case output <- median:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[float64]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Normalize is a processing stage that scales numbers of type T into the range 0 to 1 according to the minimum and
+// maximum of the entire stream. As these are not known until the stream ends, every value is held in memory and nothing
+// is passed on before then. For long or endless streams, NormalizeWindow offers an approximation. Should all values be
+// equal, each is scaled to 0.
+func Normalize[T ~float64](input Pipeline[T]) Pipeline[float64] {
+	output := make(chan float64)
+
+	input.run(func() error {
+		defer close(output)
+
+		values := make([]T, 0)
+		for value := range input.values {
+			values = append(values, value)
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if len(values) == 0 {
+			return nil
+		}
+
+		low, high := values[0], values[0]
+		for _, value := range values[1:] {
+			low, high = min(low, value), max(high, value)
+		}
+
+		for _, value := range values {
+			select {
+			case output <- normalize(value, low, high):
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[float64]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// NormalizeWindow is a processing stage that scales numbers of type T into the range 0 to 1 according to the minimum and
+// maximum among the trailing window of values, including the current one. Unlike Normalize, each value is passed on
+// immediately and memory use is bounded, but values are only comparable with their neighbors, as the scale shifts along
+// with the window. Whenever all values in the window are equal, the current one is scaled to 0.
+func NormalizeWindow[T ~float64](input Pipeline[T], window int) Pipeline[float64] {
+	if window < 1 {
+		return abort[T, float64](input, fmt.Errorf("NormalizeWindow: window must be at least 1, got %d", window))
+	}
+
+	output := make(chan float64)
+
+	input.run(func() error {
+		defer close(output)
+
+		ring := make([]T, 0, window)
+		var oldest int
+
+		for value := range input.values {
+			if len(ring) < window {
+				ring = append(ring, value)
+			} else {
+				ring[oldest] = value
+				oldest = (oldest + 1) % window
+			}
+
+			low, high := ring[0], ring[0]
+			for _, v := range ring[1:] {
+				low, high = min(low, v), max(high, v)
+			}
+
+			select {
+			case output <- normalize(value, low, high):
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[float64]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// OnToggle is a processing stage that passes values of type T through unchanged while calling the given onChange
+// function whenever the boolean state derived from a value by the state function differs from that of the value before
+// it, such as to log when a threshold is crossed. It receives the new state along with the value that brought it about.
+// The first value establishes the initial state without a call. An error from onChange aborts the Pipeline.
+func OnToggle[T any](input Pipeline[T], state func(T) bool, onChange func(context.Context, bool, T) error) Pipeline[T] {
+	var previous bool
+	first := true
+
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		current := state(value)
+		changed := !first && current != previous
+		previous, first = current, false
+
+		if changed {
+			if err := onChange(ctx, current, value); err != nil {
+				return value, err
+			}
+		}
+
+		return value, nil
+	})
+}
+
+// Pace is a processing stage that delays each value of type T by the duration the given gap function computes for it
+// before passing it on. Unlike a fixed delay, this allows pacing to follow hints carried by the values themselves. A
+// non-positive duration passes the value on immediately.
+func Pace[T any](input Pipeline[T], gap func(T) time.Duration) Pipeline[T] {
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		return value, sleep(ctx, gap(value))
+	})
+}
+
+// PageSource is a helper function around Source that generates values from a paginated collection. The given fetch
+// function is called repeatedly, beginning with the initial cursor and then with each cursor it returns in turn, and
+// the items of every page are emitted until fetch reports it is done. The items of the final page are still emitted.
+func PageSource[T, C any](ctx context.Context, initial C, fetch func(ctx context.Context, cursor C) (items []T, next C, done bool, err error)) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		cursor := initial
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			items, next, done, err := fetch(ctx, cursor)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				if err := emit(item); err != nil {
+					return err
+				}
+			}
+
+			if done {
+				return nil
+			}
+
+			cursor = next
+		}
+	})
+}
+
+// Parallel runs each of the given functions, typically each ending in the terminal processing stage of a separate
+// Pipeline, in its own goroutine. Every function is given a Context derived from ctx, which is canceled as soon as any
+// of them fails, so Pipelines built from it abort together. Parallel waits for all of them to return and gives the
+// first error encountered, if any. See ParallelJoin to run functions independently and collect every error.
+func Parallel(ctx context.Context, fns ...func(context.Context) error) error {
+	group, groupContext := errgroup.WithContext(ctx)
+
+	for _, fn := range fns {
+		fn := fn
+		group.Go(func() error {
+			return fn(groupContext)
+		})
+	}
+
+	return group.Wait()
+}
+
+// ParallelBatchMap is a processing stage that groups values of type I into batches of batchSize and converts each batch
+// into a slice of values of type O using the given function, running on up to the given number of batches at once.
+// The resulting values are passed on individually. A final, smaller batch is formed from any values left over once the
+// input is exhausted.
+//
+// This process is not guaranteed to maintain the order of the values.
+func ParallelBatchMap[I, O any](input Pipeline[I], batchSize, workers int, fn func(context.Context, []I) ([]O, error)) Pipeline[O] {
+	if batchSize < 1 || workers < 1 {
+		return abort[I, O](input, fmt.Errorf("ParallelBatchMap: batchSize and workers must be at least 1, got %d and %d", batchSize, workers))
+	}
+
+	return pool(batch(input, batchSize), workers, func(ctx context.Context, values []I, emit func(O) error) error {
+		newValues, err := fn(ctx, values)
+		if err != nil {
+			return err
+		}
+
+		for _, newValue := range newValues {
+			if err := emit(newValue); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ParallelFilter is identical to Filter except the filtering operations are performed in parallel.
+// This process is not guaranteed to maintain the order of the values.
+func ParallelFilter[T any](input Pipeline[T], filter func(context.Context, T) (bool, error)) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+		filteringGroup, filteringContext := errgroup.WithContext(input.ctx)
+
+		for value := range input.values {
+			value := value
+			filteringGroup.Go(func() error {
+				pass, err := filter(input.ctx, value)
+				if err != nil {
+					return err
+				} else if pass {
+					select {
+					case output <- value:
+					case <-filteringContext.Done():
+						return filteringContext.Err()
+					}
+				}
+
+				return nil
+			})
+		}
+
+		return filteringGroup.Wait()
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// ParallelJoin runs each of the given functions in its own goroutine, waits for all of them to return, and combines their
+// errors using errors.Join. Unlike Parallel, no Context is shared, so one failing does not disturb the others.
+func ParallelJoin(fns ...func() error) error {
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+
+	for i, fn := range fns {
+		i, fn := i, fn
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[i] = fn()
+		}()
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// ParallelMap is identical to Map except the mapping operations are performed in parallel.
+// This process is not guaranteed to maintain the order of the values.
+func ParallelMap[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+		mappingGroup, mappingContext := errgroup.WithContext(input.ctx)
+
+		for value := range input.values {
+			value := value
+			mappingGroup.Go(func() error {
+				newValue, err := mapper(mappingContext, value)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case output <- newValue:
+					return nil
+
+				case <-mappingContext.Done():
+					return mappingContext.Err()
+				}
+			})
+		}
+
+		return mappingGroup.Wait()
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// ParallelMapKeyed is identical to ParallelMap except values are spread across a fixed number of workers according to
+// the given key function. Values sharing a key are always handled by the same worker, so their relative order is
+// maintained, while values with different keys may be processed concurrently and in any order.
+//
+// Keys are assigned to workers using a hash of their formatted representation (as by fmt.Print), so keys that format
+// identically will also share a worker.
+func ParallelMapKeyed[I, O any, K comparable](input Pipeline[I], workers int, key func(I) K, mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	if workers < 1 {
+		return abort[I, O](input, fmt.Errorf("ParallelMapKeyed: workers must be at least 1, got %d", workers))
+	}
+
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+		mappingGroup, mappingContext := errgroup.WithContext(input.ctx)
+
+		queues := make([]chan I, workers)
+		for i := range queues {
+			queue := make(chan I)
+			queues[i] = queue
+
+			mappingGroup.Go(func() error {
+				for value := range queue {
+					newValue, err := mapper(mappingContext, value)
+					if err != nil {
+						return err
+					}
+
+					select {
+					case output <- newValue:
+					case <-mappingContext.Done():
+						return mappingContext.Err()
+					}
+				}
+
+				return nil
+			})
+		}
+
+		mappingGroup.Go(func() error {
+			defer func() {
+				for _, queue := range queues {
+					close(queue)
+				}
+			}()
+
+			for value := range input.values {
+				hash := fnv.New64a()
+				fmt.Fprint(hash, key(value))
+
+				select {
+				case queues[hash.Sum64()%uint64(workers)] <- value:
+				case <-mappingContext.Done():
+					return mappingContext.Err()
+				}
+			}
+
+			return nil
+		})
+
+		return mappingGroup.Wait()
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// ParallelMapRateLimited is identical to ParallelMap except mapping is performed by the given number of workers, all
+// of which share the given limiter, each waiting for a token before calling the mapper function. This holds the
+// Pipeline as a whole to the limiter's rate, such as the quota of an external API, however many workers there are.
+//
+// This process is not guaranteed to maintain the order of the values.
+func ParallelMapRateLimited[I, O any](input Pipeline[I], workers int, limiter *rate.Limiter, mapper func(context.Context, I) (O, error)) Pipeline[O] {
+	if workers < 1 {
+		return abort[I, O](input, fmt.Errorf("ParallelMapRateLimited: workers must be at least 1, got %d", workers))
+	}
+
+	return pool(input, workers, func(ctx context.Context, value I, emit func(O) error) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		newValue, err := mapper(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		return emit(newValue)
+	})
+}
+
+// ParallelMapResults is identical to ParallelMap except the outcome of every mapping operation, whether a value or an
+// error, is passed on as a Result. Errors from the mapper function therefore do not abort the Pipeline.
+//
+// This process is not guaranteed to maintain the order of the values.
+func ParallelMapResults[I, O any](input Pipeline[I], workers int, mapper func(context.Context, I) (O, error)) Pipeline[Result[O]] {
+	if workers < 1 {
+		return abort[I, Result[O]](input, fmt.Errorf("ParallelMapResults: workers must be at least 1, got %d", workers))
+	}
+
+	return pool(input, workers, func(ctx context.Context, value I, emit func(Result[O]) error) error {
+		newValue, err := mapper(ctx, value)
+		return emit(Result[O]{newValue, err})
+	})
+}
+
+// PartitionedFileSink is a terminal processing stage that appends each value of type T, using the given write function,
+// to a file within the given directory named after its partition, as given by the partition function, plus ".data".
+// The directory is created, along with any missing parents, upon the first value. Files are kept open between values,
+// with writes buffered, but no more than 64 at a time; once the limit is reached, the least recently written is closed
+// to make room. Every file is flushed and closed when the Pipeline ends, whether successfully or not. Should it abort,
+// anything already written remains in place.
+func PartitionedFileSink[T any](input Pipeline[T], dir string, partition func(T) string, write func(io.Writer, T) error) error {
+	files := make(map[string]*partitionFile)
+	var used uint64
+
+	closeFile := func(key string) error {
+		f := files[key]
+		delete(files, key)
+
+		err := f.writer.Flush()
+		if closeErr := f.file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		key := partition(value)
+
+		f, ok := files[key]
+		if !ok {
+			if used == 0 {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					return err
+				}
+			}
+
+			if len(files) >= partitionFilesOpen {
+				var oldest string
+				for k, candidate := range files {
+					if oldest == "" || candidate.used < files[oldest].used {
+						oldest = k
+					}
+				}
+
+				if err := closeFile(oldest); err != nil {
+					return err
+				}
+			}
+
+			file, err := os.OpenFile(filepath.Join(dir, key+".data"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+
+			f = &partitionFile{file: file, writer: bufio.NewWriter(file)}
+			files[key] = f
+		}
+
+		used++
+		f.used = used
+		return write(f.writer, value)
+	})
+
+	for key := range files {
+		if closeErr := closeFile(key); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// PercentileWindow is a processing stage that passes on, after each number of type T, the pth percentile of the
+// trailing window of values including it, where p is between 0 and 1 (e.g. 0.99 for p99 latency). The result is exact,
+// being the value of nearest rank within the window, which is kept as a sorted slice alongside the order of arrival. Each
+// value thus costs time proportional to the window, making this best suited to windows of up to some thousands. A NaN
+// aborts the Pipeline with ErrNaN.
+func PercentileWindow[T ~float64](input Pipeline[T], window int, p float64) Pipeline[float64] {
+	if window < 1 {
+		return abort[T, float64](input, fmt.Errorf("PercentileWindow: window must be at least 1, got %d", window))
+	} else if p < 0 || p > 1 {
+		return abort[T, float64](input, fmt.Errorf("PercentileWindow: p must be between 0 and 1, got %v", p))
+	}
+
+	output := make(chan float64)
+
+	input.run(func() error {
+		defer close(output)
+
+		values := newSortedWindow[T](window)
+
+		for value := range input.values {
+			if math.IsNaN(float64(value)) {
+				return ErrNaN
+			}
+
+			sorted := values.add(value)
+			percentile := float64(sorted[int(p*float64(len(sorted)-1)+0.5)])
+
+			select {
+			case output <- percentile:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[float64]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// PollSource is a helper function around Source that repeatedly calls the given poll function and emits the items it
+// returns. Whenever a poll comes back empty, the next is delayed by the given idle duration. Polling continues until the
+// Context is canceled or the Pipeline is otherwise stopped. The idle duration must be positive.
+func PollSource[T any](ctx context.Context, poll func(context.Context) ([]T, error), idle time.Duration) Pipeline[T] {
+	if idle <= 0 {
+		return abort[T, T](SliceSource[T](ctx, nil), fmt.Errorf("PollSource: idle must be positive, got %v", idle))
+	}
+
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		for {
+			items, err := poll(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				if err := emit(item); err != nil {
+					return err
+				}
+			}
+
+			if len(items) == 0 {
+				if err := sleep(ctx, idle); err != nil {
+					return err
+				}
+			} else if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Progress is a processing stage that passes values of type T through unchanged while reporting the fraction of the
+// given total that has arrived so far. To keep the report function from being flooded by long streams, it is called
+// only when the fraction reaches a new whole percentage, so at most 101 times. Should more than the total arrive, the
+// fraction stays at 1.
+func Progress[T any](input Pipeline[T], total int, report func(fraction float64)) Pipeline[T] {
+	if total < 1 {
+		return abort[T, T](input, fmt.Errorf("Progress: total must be at least 1, got %d", total))
+	}
+
+	var consumed int
+	lastPercent := -1
+
+	return Map(input, func(_ context.Context, value T) (T, error) {
+		if consumed < total {
+			consumed++
+		}
+
+		if percent := consumed * 100 / total; percent != lastPercent {
+			lastPercent = percent
+			report(float64(consumed) / float64(total))
+		}
+
+		return value, nil
+	})
+}
+
+// ProtoSink is a terminal processing stage that writes protobuf messages of type T to the given writer, each preceded
+// by its length as a varint, such that a ProtoSource can read them back. The writer is not closed.
+func ProtoSink[T proto.Message](input Pipeline[T], w io.Writer) error {
+	return Sink(input, func(_ context.Context, message T) error {
+		_, err := protodelim.MarshalTo(w, message)
+		return err
+	})
+}
+
+// ProtoSource is a helper function around Source that generates protobuf messages of type T by reading them from the
+// given reader until it reaches EOF, each preceded by its length as a varint, as written by ProtoSink. Every message is
+// decoded into a fresh value obtained from newMessage. Cancellation is checked between messages, but a read already
+// blocked on the reader cannot be interrupted except by the reader itself, such as by closing it or setting a deadline.
+func ProtoSource[T proto.Message](ctx context.Context, r io.Reader, newMessage func() T) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		reader := bufio.NewReader(r)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			message := newMessage()
+			if err := protodelim.UnmarshalFrom(reader, message); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			if err := emit(message); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Rate is a processing stage that, for each value of type T it receives, emits the current throughput in values per
+// second instead of the value itself. The rate is the number of arrivals within the trailing window of time, including
+// the current one, divided by the length of the window. It is thus only updated as values arrive, and understates the
+// throughput until a full window has passed. See RateTap to pass the values on as well.
+func Rate[T any](input Pipeline[T], window time.Duration) Pipeline[float64] {
+	if window <= 0 {
+		return abort[T, float64](input, fmt.Errorf("Rate: window must be positive, got %v", window))
+	}
+
+	observe := rateMeter(window)
+
+	return Map(input, func(_ context.Context, _ T) (float64, error) {
+		return observe(), nil
+	})
+}
+
+// RateTap is identical to Rate except the values themselves are passed through unchanged, while the rate is given to
+// the report function upon each arrival.
+func RateTap[T any](input Pipeline[T], window time.Duration, report func(float64)) Pipeline[T] {
+	if window <= 0 {
+		return abort[T, T](input, fmt.Errorf("RateTap: window must be positive, got %v", window))
+	}
+
+	observe := rateMeter(window)
+
+	return Map(input, func(_ context.Context, value T) (T, error) {
+		report(observe())
+		return value, nil
+	})
+}
+
+// Rechunk is a processing stage that regroups the elements of incoming slices into slices of the given size,
+// irrespective of where the original slices began and ended. The final slice may be shorter, but none are empty.
+func Rechunk[T any](input Pipeline[[]T], size int) Pipeline[[]T] {
+	if size < 1 {
+		return abort[[]T, []T](input, fmt.Errorf("Rechunk: size must be at least 1, got %d", size))
+	}
+
+	return batch(Flatten(input), size)
+}
+
+// Record is a processing stage that passes values of type T through unchanged while recording them in the returned
+// Rewindable, from which they can later be replayed into other stages without fetching them again. Every value is held
+// in memory for as long as the Rewindable is. Replays run within the given Context.
+func Record[T any](ctx context.Context, input Pipeline[T]) (Pipeline[T], *Rewindable[T]) {
+	recording := &Rewindable[T]{ctx: ctx}
+
+	return Map(input, func(_ context.Context, value T) (T, error) {
+		recording.mu.Lock()
+		recording.values = append(recording.values, value)
+		recording.mu.Unlock()
+
+		return value, nil
+	}), recording
+}
+
+// Reduce is a terminal processing stage that consumes values of type I and reduces them down to a single value of type O
+// using the given reducer function, beginning with the given initial state.
+func Reduce[I, O any](input Pipeline[I], reducer func(context.Context, I, O) (O, error), initialState O) (O, error) {
+	currentState := initialState
+
+	input.run(func() error {
+		for value := range input.values {
+			newState, err := reducer(input.ctx, value, currentState)
+			if err != nil {
+				return err
+			}
+
+			currentState = newState
+		}
+
+		return nil
+	})
+
+	err := input.wait()
+	return currentState, err
+}
+
+// ReduceUntil is a processing stage that accumulates values of type I into a state of type O using the given reducer
+// function, beginning with the given initial state. After each value, if the shouldEmit function approves of the state,
+// it is passed on and accumulation begins again from initial. Note initial is reused as is, so it should not be a map,
+// slice, or pointer that the reducer modifies in place. Once the input is exhausted, any state accumulated since the last
+// one passed on is passed on as well, even though shouldEmit has not approved of it.
+func ReduceUntil[I, O any](input Pipeline[I], reducer func(context.Context, O, I) (O, error), shouldEmit func(O) bool, initial O) Pipeline[O] {
+	output := make(chan O)
+
+	input.run(func() error {
+		defer close(output)
+
+		state := initial
+		pending := false
+
+		emit := func() error {
+			select {
+			case output <- state:
+				state, pending = initial, false
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for value := range input.values {
+			var err error
+			if state, err = reducer(input.ctx, state, value); err != nil {
+				return err
+			}
+			pending = true
+
+			if shouldEmit(state) {
+				if err := emit(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := input.ctx.Err(); err != nil || !pending {
+			return err
+		}
+
+		return emit()
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Resample is a processing stage that passes on values of type T at a fixed interval regardless of the rate at which
+// they arrive. On every tick the most recently received value is passed on, so a value is repeated on each tick until
+// the next arrives, while of several arriving between ticks only the last is kept. Ticks begin once the first value
+// has arrived, and stop once the input is exhausted. Ticks missed because the next stage was slow to receive are
+// skipped rather than caught up on.
+func Resample[T any](input Pipeline[T], interval time.Duration) Pipeline[T] {
+	if interval <= 0 {
+		return abort[T, T](input, fmt.Errorf("Resample: interval must be positive, got %v", interval))
+	}
+
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var latest T
+		var ticks <-chan time.Time
+
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+
+				latest = value
+				if ticks == nil {
+					ticker := time.NewTicker(interval)
+					defer ticker.Stop()
+					ticks = ticker.C
+				}
+
+			case <-ticks:
+				select {
+				case output <- latest:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// ReservoirSample is a terminal processing stage that consumes values of type T and returns a uniformly random sample
+// of k of them, using reservoir sampling to hold no more than k values at a time. Fewer than k values are returned if
+// the Pipeline produces fewer than k.
+func ReservoirSample[T any](input Pipeline[T], k int) ([]T, error) {
+	return ReservoirSampleRand(input, k, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// ReservoirSampleRand is identical to ReservoirSample except the given random-number generator is used to choose the
+// sample, making the choice reproducible.
+func ReservoirSampleRand[T any](input Pipeline[T], k int, rng *rand.Rand) ([]T, error) {
+	sample := make([]T, 0)
+	var seen int64
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		seen++
+
+		if len(sample) < k {
+			sample = append(sample, value)
+		} else if i := rng.Int63n(seen); i < int64(k) {
+			sample[i] = value
+		}
+
+		return nil
+	})
+
+	return sample, err
+}
+
+// ResultChannelSource is a helper function around Source that generates values from the given channel of Results. The
+// Value of each Result is emitted until the channel is closed, unless a Result carries an error, in which case the
+// Pipeline fails with that error instead.
+func ResultChannelSource[T any](ctx context.Context, ch <-chan Result[T]) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		for {
+			select {
+			case result, ok := <-ch:
+				if !ok {
+					return nil
+				} else if result.Err != nil {
+					return result.Err
+				}
+
+				if err := emit(result.Value); err != nil {
+					return err
+				}
+
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+// RollingReduce is a processing stage that maintains an aggregate of type A over the most recent values of type T,
+// passing on its current state after each value. Every value is added to the aggregate using the given reducer function
+// and, once it leaves the window, taken back out using the given remove function. For the result to be correct, remove
+// must exactly undo reducer, as subtraction undoes addition. This gives rolling sums and the like in constant time per
+// value, without recomputing the whole window.
+func RollingReduce[T, A any](input Pipeline[T], window int, reducer func(A, T) A, remove func(A, T) A, initial A) Pipeline[A] {
+	if window < 1 {
+		return abort[T, A](input, fmt.Errorf("RollingReduce: window must be at least 1, got %d", window))
+	}
+
+	output := make(chan A)
+
+	input.run(func() error {
+		defer close(output)
+
+		state := initial
+		ring := make([]T, 0, window)
+		var oldest int
+
+		for value := range input.values {
+			if len(ring) < window {
+				ring = append(ring, value)
+			} else {
+				state = remove(state, ring[oldest])
+				ring[oldest] = value
+				oldest = (oldest + 1) % window
+			}
+			state = reducer(state, value)
+
+			select {
+			case output <- state:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[A]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Route is a processing stage that converts values of type I into values of type O using whichever of the given mapper
+// functions is chosen for each by the route function, which returns its index. An index out of range fails the
+// Pipeline.
+func Route[I, O any](input Pipeline[I], route func(I) int, mappers ...func(context.Context, I) (O, error)) Pipeline[O] {
+	return Map(input, func(ctx context.Context, value I) (O, error) {
+		i := route(value)
+		if i < 0 || i >= len(mappers) {
+			var zero O
+			return zero, fmt.Errorf("Route: index %d out of range for %d mappers", i, len(mappers))
+		}
+
+		return mappers[i](ctx, value)
+	})
+}
+
+// SQLBatchSink is a terminal processing stage that writes values of type T to a database in batches of the given size.
+// For each batch a transaction is begun, the given insert function called with it, and the transaction committed. The
+// final batch may be shorter. If insert fails, the transaction is rolled back and the Pipeline aborted. Should the
+// Pipeline be cancelled while a transaction is in progress, that transaction is rolled back as well.
+func SQLBatchSink[T any](input Pipeline[T], db *sql.DB, batchSize int, insert func(ctx context.Context, tx *sql.Tx, batch []T) error) error {
+	var batches Pipeline[[]T]
+	if batchSize < 1 {
+		batches = abort[T, []T](input, fmt.Errorf("SQLBatchSink: batchSize must be at least 1, got %d", batchSize))
+	} else {
+		batches = batch(input, batchSize)
+	}
+
+	return Sink(batches, func(ctx context.Context, values []T) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := insert(ctx, tx, values); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// Seq2Source is a helper function around Source that generates values from the given iterator, which yields each value
+// alongside an error. The Pipeline fails with the first non-nil error yielded. When the Pipeline is canceled, iteration
+// is stopped the next time a value is yielded, allowing the iterator to clean up after itself.
+func Seq2Source[T any](ctx context.Context, seq iter.Seq2[T, error]) Pipeline[T] {
+	return Source(ctx, func(_ context.Context, emit func(T) error) error {
+		for value, err := range seq {
+			if err != nil {
+				return err
+			}
+
+			if err := emit(value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SessionWindow is a processing stage that groups values of type T into sessions according to the time given for each by
+// the timeOf function. A session ends when the next value's time is more than the given gap after that of the value
+// before it. Each session is passed on as a slice once it ends, and the last is passed on when the input is exhausted.
+//
+// Only the times of consecutive values are compared, in the order they arrive. A value whose time is earlier than its
+// predecessor's is therefore always placed in the same session, and may be followed by a gap that its predecessor
+// alone would not have caused.
+func SessionWindow[T any](input Pipeline[T], gap time.Duration, timeOf func(T) time.Time) Pipeline[[]T] {
+	if gap < 0 {
+		return abort[T, []T](input, fmt.Errorf("SessionWindow: gap must not be negative, got %v", gap))
+	}
+
+	output := make(chan []T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var session []T
+		var last time.Time
+
+		flush := func() error {
+			select {
+			case output <- session:
+				session = nil
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for value := range input.values {
+			t := timeOf(value)
+
+			if len(session) > 0 && t.Sub(last) > gap {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+			session = append(session, value)
+			last = t
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if len(session) > 0 {
+			return flush()
+		}
+
+		return nil
+	})
+
+	return Pipeline[[]T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Single is a terminal processing stage that returns the one value of type T the Pipeline is expected to produce. It
+// fails with ErrNoValues if there are none, or with ErrMultipleValues as soon as a second arrives, cancelling the rest
+// of the Pipeline.
+func Single[T any](input Pipeline[T]) (T, error) {
+	var single T
+	var found bool
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		if found {
+			return ErrMultipleValues
+		}
+
+		single = value
+		found = true
+		return nil
+	})
+
+	if err == nil && !found {
+		err = ErrNoValues
+	}
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return single, nil
+}
+
+// Sink is a terminal processing stage that consumes values of type T using the given sink function. Any error generated
+// by the Pipeline's errgroup will be returned here.
+func Sink[T any](input Pipeline[T], sink func(context.Context, T) error) error {
+	input.run(func() error {
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+				if err := sink(input.ctx, value); err != nil {
+					return err
+				}
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+	})
+
+	return input.wait()
+}
+
+// SinkReduce is a terminal processing stage that both consumes values of type T using the given sink function and
+// reduces them down to a single value of type A using the given reducer function, beginning with the given initial
+// state. For each value the sink function is called first, followed by the reducer.
+func SinkReduce[T, A any](input Pipeline[T], sink func(context.Context, T) error, reducer func(context.Context, A, T) (A, error), initial A) (A, error) {
+	currentState := initial
+
+	err := Sink(input, func(ctx context.Context, value T) error {
+		if err := sink(ctx, value); err != nil {
+			return err
+		}
+
+		newState, err := reducer(ctx, currentState, value)
+		if err != nil {
+			return err
+		}
+
+		currentState = newState
+		return nil
+	})
+
+	return currentState, err
+}
+
+// SinkTimeout is identical to Sink except each call to the sink function must complete within the given duration. The
+// sink function receives a Context carrying the per-value deadline. Should it fail to return in time, the Pipeline is
+// aborted with context.DeadlineExceeded without waiting any further; the stuck call is left to finish in the background.
+// The duration must be positive.
+func SinkTimeout[T any](input Pipeline[T], perItem time.Duration, sink func(context.Context, T) error) error {
+	if perItem <= 0 {
+		input = abort[T, T](input, fmt.Errorf("SinkTimeout: perItem must be positive, got %v", perItem))
+	}
+
+	return Sink(input, func(ctx context.Context, value T) error {
+		itemContext, cancel := context.WithTimeout(ctx, perItem)
+		defer cancel()
+
+		result := make(chan error, 1)
+		go func() {
+			result <- sink(itemContext, value)
+		}()
+
+		select {
+		case err := <-result:
+			return err
+
+		case <-itemContext.Done():
+			select {
+			case err := <-result:
+				return err
+			default:
+				return itemContext.Err()
+			}
+		}
+	})
+}
+
+// SinkToResultChannel is a terminal processing stage that forwards each value onto the given channel wrapped in a
+// Result. If the pipeline fails, a final Result carrying the error is sent before the error is also returned, so that
+// consumers of the channel see values and failures alike. Sends of values give up once the pipeline is canceled, and
+// the final Result is not sent if the error is itself a context cancellation or deadline, as the receiver is then
+// presumed to have gone away. Otherwise, should the receiver have stopped draining the channel, the final send waits
+// until the given done channel is closed, which the caller should therefore do once it stops receiving. A nil done
+// channel makes the final send wait indefinitely.
+//
+// The channel belongs to the caller and is not closed.
+func SinkToResultChannel[T any](input Pipeline[T], out chan<- Result[T], done <-chan struct{}) error {
+	err := Sink(input, func(ctx context.Context, value T) error {
+		select {
+		case out <- Result[T]{Value: value}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		select {
+		case out <- Result[T]{Err: err}:
+		case <-done:
+		}
+	}
+
+	return err
+}
+
+// SinkWithUnprocessed is identical to Sink except it also reports how many values were consumed successfully and, if
+// the Pipeline fails, which values reached this stage without being consumed. The latter includes the value whose sink
+// function returned an error as well as any values delivered by the preceding stage while the Pipeline was being torn
+// down. Values still held by earlier stages at that point are not recoverable.
+func SinkWithUnprocessed[T any](input Pipeline[T], sink func(context.Context, T) error) (processed int, unprocessed []T, err error) {
+	unprocessed = make([]T, 0)
+
+	drain := func() error {
+		for value := range input.values {
+			unprocessed = append(unprocessed, value)
+		}
+
+		return nil
+	}
+
+	input.run(func() error {
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+
+				if err := sink(input.ctx, value); err != nil {
+					unprocessed = append(unprocessed, value)
+					input.run(drain)
+					return err
+				}
+				processed++
+
+			case <-input.ctx.Done():
+				input.run(drain)
+				return input.ctx.Err()
+			}
+		}
+	})
+
+	err = input.wait()
+	return processed, unprocessed, err
+}
+
+// SliceSource is a helper function around Source that generates values from the given slice.
+func SliceSource[T any](ctx context.Context, slice []T) Pipeline[T] {
+	return Source(ctx, func(_ context.Context, emit func(T) error) error {
+		for _, value := range slice {
+			if err := emit(value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SliceSourceTracked is identical to SliceSource except it also returns a function reporting the index of the last
+// value successfully emitted, or -1 if there is none. Should the Pipeline be aborted, this tells where to resume. The
+// function is safe to call at any time, including concurrently and after the Pipeline has finished.
+func SliceSourceTracked[T any](ctx context.Context, slice []T) (Pipeline[T], func() int) {
+	var last atomic.Int64
+	last.Store(-1)
+
+	pipeline := Source(ctx, func(_ context.Context, emit func(T) error) error {
+		for i, value := range slice {
+			if err := emit(value); err != nil {
+				return err
+			}
+
+			last.Store(int64(i))
+		}
+
+		return nil
+	})
+
+	return pipeline, func() int {
+		return int(last.Load())
+	}
+}
+
+// SortExternal is a processing stage that sorts values of type T according to the given less function, which must
+// describe a strict weak ordering. Up to maxInMemory values are held at once. Beyond that, sorted runs are spilled to
+// temporary files using the given Codec and merged back together once the input is exhausted, allowing streams larger
+// than memory to be sorted. Runs are merged at most 64 at a time, combining them into longer intermediate runs as they
+// accumulate, so the number of files open at once stays bounded however long the stream. The sort is stable. Temporary
+// files are removed when the stage ends, whether it succeeded or not.
+func SortExternal[T any](input Pipeline[T], less func(a, b T) bool, codec Codec[T], maxInMemory int) Pipeline[T] {
+	if maxInMemory < 1 {
+		return abort[T, T](input, fmt.Errorf("SortExternal: maxInMemory must be at least 1, got %d", maxInMemory))
+	}
+
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		// A run is a sorted temporary file. Its level counts the merges that produced it, so that runs are only merged
+		// with others of similar length and each value is rewritten about once per level.
+		type run struct {
+			name  string
+			level int
+		}
+
+		var runs []run
+		discard := func(discarded []run) {
+			for _, r := range discarded {
+				os.Remove(r.name)
+			}
+		}
+		defer func() {
+			discard(runs)
+		}()
+
+		spill := func(level int, write func(encode func(T) error) error) error {
+			file, err := os.CreateTemp("", "fngo-sort-*")
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run{name: file.Name(), level: level})
+
+			writer := bufio.NewWriter(file)
+			err = write(func(value T) error {
+				return codec.Encode(writer, value)
+			})
+			if err == nil {
+				err = writer.Flush()
+			}
+
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+
+		// open returns a function reading back each of the given runs, along with one closing their files.
+		open := func(opened []run) ([]func() (T, error), func(), error) {
+			files := make([]*os.File, 0, len(opened))
+			closeAll := func() {
+				for _, file := range files {
+					file.Close()
+				}
+			}
+
+			sources := make([]func() (T, error), 0, len(opened)+1)
+			for _, r := range opened {
+				file, err := os.Open(r.name)
+				if err != nil {
+					closeAll()
+					return nil, nil, err
+				}
+				files = append(files, file)
+
+				reader := bufio.NewReader(file)
+				sources = append(sources, func() (T, error) {
+					return codec.Decode(reader)
+				})
+			}
+
+			return sources, closeAll, nil
+		}
+
+		merge := func(sources []func() (T, error), emit func(T) error) error {
+			heads := &heapOf[mergeCursor[T]]{less: func(a, b mergeCursor[T]) bool {
+				if less(a.value, b.value) {
+					return true
+				} else if less(b.value, a.value) {
+					return false
+				}
+
+				return a.source < b.source
+			}}
+
+			advance := func(source int) error {
+				value, err := sources[source]()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				heap.Push(heads, mergeCursor[T]{value: value, source: source})
+				return nil
+			}
+
+			for source := range sources {
+				if err := advance(source); err != nil {
+					return err
+				}
+			}
+
+			for heads.Len() > 0 {
+				head := heap.Pop(heads).(mergeCursor[T])
+				if err := emit(head.value); err != nil {
+					return err
+				}
+
+				if err := advance(head.source); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		// compact merges the newest runs into one for as long as there are too many to merge at once. Unless forced, it
+		// only does so once they share a level. Merging only the newest runs keeps them in order, so the sort stays stable.
+		compact := func(force bool) error {
+			for len(runs) >= sortExternalFanIn {
+				merged := runs[len(runs)-sortExternalFanIn:]
+				if !force && merged[0].level != merged[len(merged)-1].level {
+					return nil
+				}
+
+				merged = append([]run(nil), merged...)
+				runs = runs[:len(runs)-len(merged)]
+
+				sources, closeAll, err := open(merged)
+				if err == nil {
+					err = spill(merged[0].level+1, func(encode func(T) error) error {
+						return merge(sources, encode)
+					})
+					closeAll()
+				}
+
+				discard(merged)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		buffer := make([]T, 0)
+		sortBuffer := func() {
+			sort.SliceStable(buffer, func(i, j int) bool {
+				return less(buffer[i], buffer[j])
+			})
+		}
+
+		for value := range input.values {
+			buffer = append(buffer, value)
+			if len(buffer) < maxInMemory {
+				continue
+			}
+
+			sortBuffer()
+
+			err := spill(0, func(encode func(T) error) error {
+				for _, value := range buffer {
+					if err := encode(value); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			buffer = buffer[:0]
+
+			if err := compact(false); err != nil {
+				return err
+			}
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		}
+
+		sortBuffer()
+
+		if err := compact(true); err != nil {
+			return err
+		}
+
+		sources, closeAll, err := open(runs)
+		if err != nil {
+			return err
+		}
+		defer closeAll()
+
+		sources = append(sources, func() (T, error) {
+			if len(buffer) == 0 {
+				return *new(T), io.EOF
+			}
+
+			value := buffer[0]
+			buffer = buffer[1:]
+			return value, nil
+		})
+
+		return merge(sources, func(value T) error {
+			select {
+			case output <- value:
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		})
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// Source is a processing stage that generates values of type T using the given source function.
+// This and all subsequent stages will run within an errgroup created from the given Context.
+//
+// The channel passed to the generator function is automatically closed when the function returns.
+func Source[T any](ctx context.Context, source func(context.Context, func(T) error) error) Pipeline[T] {
+	group, groupContext := newStageGroup(ctx)
+	output := make(chan T)
+
+	group.Go(func() error {
+		defer close(output)
+
+		emit := func(value T) error {
+			select {
+			case output <- value:
+				return nil
+			case <-groupContext.Done():
+				return groupContext.Err()
+			}
+		}
+
+		return source(groupContext, emit)
+	})
+
+	return Pipeline[T]{
+		ctx:    groupContext,
+		group:  group,
+		values: output,
+	}
+}
+
+// SourceRecover is identical to Source except the source function is called again whenever it fails, for as long as the
+// given Retryer allows, waiting between attempts as it directs. Stages downstream carry on undisturbed throughout. The
+// attempt number given to the Retryer counts consecutive failures, starting over once a restarted source emits a value.
+// Once the Retryer gives up, the Pipeline is aborted with the last error returned by the source.
+//
+// Values already emitted cannot be recalled, so each restart must resume where the last left off, such as from a
+// checkpoint kept by the caller. Otherwise values are delivered at least once, with those emitted before a failure
+// possibly emitted again.
+func SourceRecover[T any](ctx context.Context, r Retryer, source func(context.Context, func(T) error) error) Pipeline[T] {
+	return Source(ctx, func(ctx context.Context, emit func(T) error) error {
+		var progressed bool
+
+		track := func(value T) error {
+			progressed = true
+			return emit(value)
+		}
+
+		for attempt := 1; ; attempt++ {
+			progressed = false
+
+			err := source(ctx, track)
+			if err == nil || ctx.Err() != nil {
+				return err
+			} else if progressed {
+				attempt = 1
+			}
+
+			delay, retry := r.Next(attempt, err)
+			if !retry {
+				return err
+			}
+
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// SplitLines is a processing stage that reassembles chunks of bytes, such as successive reads from a network
+// connection, into lines of text, regardless of where the chunks begin and end. Lines are passed on without their
+// terminating newline or any carriage return preceding it. A final line lacking a newline is passed on as well,
+// provided it is not empty.
+func SplitLines(input Pipeline[[]byte]) Pipeline[string] {
+	output := make(chan string)
+
+	input.run(func() error {
+		defer close(output)
+
+		var pending []byte
+
+		emit := func(line []byte) error {
+			select {
+			case output <- string(bytes.TrimSuffix(line, []byte("\r"))):
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for chunk := range input.values {
+			pending = append(pending, chunk...)
+
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+
+				if err := emit(pending[:i]); err != nil {
+					return err
+				}
+				pending = pending[i+1:]
+			}
+
+			// Copy the incomplete remainder so the lines already passed on can be freed.
+			pending = append(pending[:0:0], pending...)
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if len(pending) > 0 {
+			return emit(pending)
+		}
+
+		return nil
+	})
+
+	return Pipeline[string]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// SplitStream is a processing stage that divides values of type T between two pipelines according to whether the given
+// predicate function returns true or false, respectively. Both pipelines share the errgroup of the input, so they must
+// be consumed concurrently, and an error in either branch aborts both.
+//
+// Values are routed one at a time without buffering. Should the consumer of one branch stall, the next value destined
+// for it holds up the other branch as well until the stall clears.
+func SplitStream[T any](input Pipeline[T], pred func(context.Context, T) (bool, error)) (Pipeline[T], Pipeline[T]) {
+	matched := make(chan T)
+	unmatched := make(chan T)
+
+	input.group.fork()
+
+	input.run(func() error {
+		defer close(matched)
+		defer close(unmatched)
+
+		for value := range input.values {
+			match, err := pred(input.ctx, value)
+			if err != nil {
+				return err
+			}
+
+			output := unmatched
+			if match {
+				output = matched
+			}
+
+			select {
+			case output <- value:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: matched,
+	}, Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: unmatched,
+	}
+}
+
+// Stats is a terminal processing stage that consumes numbers of type T and summarizes them in a single pass. An empty
+// Pipeline yields a zero Summary, which can be recognized by its Count, rather than an error.
+func Stats[T Number](input Pipeline[T]) (Summary, error) {
+	var summary Summary
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		number := float64(value)
+
+		if summary.Count == 0 || number < summary.Min {
+			summary.Min = number
+		}
+		if summary.Count == 0 || number > summary.Max {
+			summary.Max = number
+		}
+
+		summary.Count++
+		summary.Sum += number
+		return nil
+	})
+
+	if summary.Count > 0 {
+		summary.Mean = summary.Sum / float64(summary.Count)
+	}
+
+	return summary, err
+}
+
+// StopWhen is a processing stage that passes on values of type T until one satisfies the given predicate function. That
+// value is dropped, the output closed, and the stages before StopWhen canceled, while the stages after it finish with
+// the values they have. To allow this, the stages after StopWhen run in a new errgroup of their own, as with Catch.
+// Errors arising on either side before the predicate is satisfied still abort the whole Pipeline.
+func StopWhen[T any](input Pipeline[T], pred func(context.Context, T) (bool, error)) Pipeline[T] {
+	return cutoff(input, func(ctx context.Context, value T) (bool, bool, error) {
+		stop, err := pred(ctx, value)
+		return !stop, stop, err
+	})
+}
+
+// StratifiedSample is a processing stage that passes on up to perKey values of type T for each distinct key produced by
+// the given function, dropping the rest. The values kept are simply the first to arrive for each key, not a random
+// selection. A count is held for every key seen, so memory is bounded by the number of distinct keys.
+func StratifiedSample[T any, K comparable](input Pipeline[T], key func(T) K, perKey int) Pipeline[T] {
+	if perKey < 1 {
+		return abort[T, T](input, fmt.Errorf("StratifiedSample: perKey must be at least 1, got %d", perKey))
+	}
+
+	counts := make(map[K]int)
+
+	return Filter(input, func(_ context.Context, value T) (bool, error) {
+		k := key(value)
+		if counts[k] >= perKey {
+			return false, nil
+		}
+
+		counts[k]++
+		return true, nil
+	})
+}
+
+// SyncMapSource is a helper function around Source that generates a Pair for each entry of the given sync.Map. Entries
+// are visited as by the map's Range method, so none is visited twice, but entries stored or deleted concurrently may or
+// may not be visited. The Pipeline fails if a key or value is not of type K or V, respectively.
+func SyncMapSource[K comparable, V any](ctx context.Context, m *sync.Map) Pipeline[Pair[K, V]] {
+	return Source(ctx, func(_ context.Context, emit func(Pair[K, V]) error) error {
+		var err error
+
+		m.Range(func(k, v any) bool {
+			key, ok := k.(K)
+			if !ok {
+				err = fmt.Errorf("SyncMapSource: key %v has type %T, want %s", k, k, reflect.TypeOf((*K)(nil)).Elem())
+				return false
+			}
+
+			value, ok := v.(V)
+			if !ok {
+				err = fmt.Errorf("SyncMapSource: value for key %v has type %T, want %s", k, v, reflect.TypeOf((*V)(nil)).Elem())
+				return false
+			}
+
+			err = emit(Pair[K, V]{key, value})
+			return err == nil
+		})
+
+		return err
+	})
+}
+
+// TakeSlice is a terminal processing stage that collects up to the first n values of type T into a slice. Once n values
+// have been collected, the rest of the Pipeline is cancelled. A shorter slice is returned if fewer than n values are
+// produced.
+func TakeSlice[T any](input Pipeline[T], n int) ([]T, error) {
+	values := make([]T, 0)
+
+	input.run(func() error {
+		for len(values) < n {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+				values = append(values, value)
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return errStopped
+	})
+
+	err := input.wait()
+	return values, err
+}
+
+// TapEvery is a processing stage that passes values of type T through unchanged while calling the given function on
+// every nth one, along with the number of values seen so far (counting from 1). This suits periodic progress
+// reporting. An error returned by the function aborts the Pipeline.
+func TapEvery[T any](input Pipeline[T], every int, fn func(context.Context, int, T) error) Pipeline[T] {
+	if every < 1 {
+		return abort[T, T](input, fmt.Errorf("TapEvery: every must be at least 1, got %d", every))
+	}
+
+	count := 0
+
+	return Map(input, func(ctx context.Context, value T) (T, error) {
+		count++
+
+		if count%every == 0 {
+			if err := fn(ctx, count, value); err != nil {
+				return value, err
+			}
+		}
+
+		return value, nil
+	})
+}
+
+// ToMap is a terminal processing stage that consumes values of type T and gathers them into a map. Each value's key and
+// map value are produced by the given key and val functions, respectively. Should two values share a key, the later
+// one wins.
+func ToMap[T any, K comparable, V any](input Pipeline[T], key func(context.Context, T) (K, error), val func(context.Context, T) (V, error)) (map[K]V, error) {
+	return ToMapCombine(input, key, val, func(_ context.Context, _, newValue V) (V, error) {
+		return newValue, nil
+	})
+}
+
+// ToMapCombine is identical to ToMap except values sharing a key are merged using the given combine function, which
+// receives the value already in the map followed by the new one.
+func ToMapCombine[T any, K comparable, V any](input Pipeline[T], key func(context.Context, T) (K, error), val func(context.Context, T) (V, error), combine func(context.Context, V, V) (V, error)) (map[K]V, error) {
+	result := make(map[K]V)
+
+	err := Sink(input, func(ctx context.Context, value T) error {
+		k, err := key(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		v, err := val(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		if existing, ok := result[k]; ok {
+			if v, err = combine(ctx, existing, v); err != nil {
+				return err
+			}
+		}
+
+		result[k] = v
+		return nil
+	})
+
+	return result, err
+}
+
+// ToMapUnique is identical to ToMap except the Pipeline fails with ErrDuplicateKey should two values share a key.
+func ToMapUnique[T any, K comparable, V any](input Pipeline[T], key func(context.Context, T) (K, error), val func(context.Context, T) (V, error)) (map[K]V, error) {
+	result := make(map[K]V)
+
+	err := Sink(input, func(ctx context.Context, value T) error {
+		k, err := key(ctx, value)
+		if err != nil {
+			return err
+		} else if _, ok := result[k]; ok {
+			return fmt.Errorf("%w: %v", ErrDuplicateKey, k)
+		}
+
+		v, err := val(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		result[k] = v
+		return nil
+	})
+
+	return result, err
+}
+
+// ToSliceStage is a processing stage that gathers every value of type T into a single slice, which it emits once the
+// input is exhausted. This is the inverse of Flatten. The entire stream is held in memory, and nothing at all is emitted
+// if the Pipeline is aborted beforehand. An empty input yields an empty slice.
+func ToSliceStage[T any](input Pipeline[T]) Pipeline[[]T] {
+	output := make(chan []T)
+
+	input.run(func() error {
+		defer close(output)
+
+		values := make([]T, 0)
+		for value := range input.values {
+			values = append(values, value)
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		}
+
+		select {
+		case output <- values:
+			return nil
+		case <-input.ctx.Done():
+			return input.ctx.Err()
+		}
+	})
+
+	return Pipeline[[]T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// TopK is a terminal processing stage that returns the k greatest values of type T according to the given less function,
+// sorted from greatest to least. Only k values are held at any one time, in a min-heap, so memory use does not grow
+// with the length of the stream. A shorter slice is returned if fewer than k values are produced.
+func TopK[T any](input Pipeline[T], k int, less func(a, b T) bool) ([]T, error) {
+	top := &heapOf[T]{items: make([]T, 0), less: less}
+
+	err := Sink(input, func(_ context.Context, value T) error {
+		if top.Len() < k {
+			heap.Push(top, value)
+		} else if k < 1 {
+			return errStopped
+		} else if less(top.items[0], value) {
+			top.items[0] = value
+			heap.Fix(top, 0)
+		}
+
+		return nil
+	})
+
+	sort.Slice(top.items, func(i, j int) bool {
+		return less(top.items[j], top.items[i])
+	})
+
+	return top.items, err
+}
+
+// TotalLimit is a processing stage that passes on values of type T until their combined size, as given by the sizeOf
+// function, reaches the given limit, such as to process at most a gigabyte from an endless source. The value that
+// reaches or crosses the limit is still passed on, so the total may exceed it by up to one value, and a first value
+// larger than the limit is passed on alone.
+//
+// Once the limit is reached, the output is closed and the stages before TotalLimit are canceled, while the stages after
+// it finish with the values they have. To allow this, the stages after TotalLimit run in a new errgroup of their own, as
+// with Catch. Errors arising on either side before the limit is reached still abort the whole Pipeline.
+func TotalLimit[T any](input Pipeline[T], limit int64, sizeOf func(T) int64) Pipeline[T] {
+	if limit < 1 {
+		return abort[T, T](input, fmt.Errorf("TotalLimit: limit must be at least 1, got %d", limit))
+	}
+
+	var total int64
+
+	return cutoff(input, func(_ context.Context, value T) (bool, bool, error) {
+		total += sizeOf(value)
+		return true, total >= limit, nil
+	})
+}
+
+// Tuples2 is a processing stage that groups consecutive values of type T into Pairs, without overlap. A final value
+// left without a partner is dropped. See Tuples2Strict to treat it as an error instead.
+func Tuples2[T any](input Pipeline[T]) Pipeline[Pair[T, T]] {
+	return tuples(input, 2, false, func(values []T) Pair[T, T] {
+		return Pair[T, T]{values[0], values[1]}
+	})
+}
+
+// Tuples2Strict is identical to Tuples2 except a final value left without a partner fails the Pipeline with
+// ErrIncompleteTuple.
+func Tuples2Strict[T any](input Pipeline[T]) Pipeline[Pair[T, T]] {
+	return tuples(input, 2, true, func(values []T) Pair[T, T] {
+		return Pair[T, T]{values[0], values[1]}
+	})
+}
+
+// Tuples3 is a processing stage that groups consecutive values of type T into Triples, without overlap. Any final
+// values too few to fill a Triple are dropped. See Tuples3Strict to treat them as an error instead.
+func Tuples3[T any](input Pipeline[T]) Pipeline[Triple[T, T, T]] {
+	return tuples(input, 3, false, func(values []T) Triple[T, T, T] {
+		return Triple[T, T, T]{values[0], values[1], values[2]}
+	})
+}
+
+// Tuples3Strict is identical to Tuples3 except final values too few to fill a Triple fail the Pipeline with
+// ErrIncompleteTuple.
+func Tuples3Strict[T any](input Pipeline[T]) Pipeline[Triple[T, T, T]] {
+	return tuples(input, 3, true, func(values []T) Triple[T, T, T] {
+		return Triple[T, T, T]{values[0], values[1], values[2]}
+	})
+}
+
+// Validate is a processing stage that checks values of type T using each of the given validator functions in turn,
+// passing on only those values for which none of them return an error. This is a fail-fast check: the first error
+// returned by a validator aborts the Pipeline.
+func Validate[T any](input Pipeline[T], validators ...func(context.Context, T) error) Pipeline[T] {
+	return Filter(input, func(ctx context.Context, value T) (bool, error) {
+		for _, validator := range validators {
+			if err := validator(ctx, value); err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	})
+}
+
+// ValidateDeadLetter is identical to Validate except invalid values do not abort the Pipeline. Instead, every validator
+// is run on each value, and those failing any of them are diverted into a second pipeline as Results carrying the value
+// and the errors of all failed validators joined together. Both pipelines share the errgroup of the input and must be
+// consumed concurrently, as with SplitStream.
+func ValidateDeadLetter[T any](input Pipeline[T], validators ...func(context.Context, T) error) (Pipeline[T], Pipeline[Result[T]]) {
+	valid := make(chan T)
+	invalid := make(chan Result[T])
+
+	input.group.fork()
+
+	input.run(func() error {
+		defer close(valid)
+		defer close(invalid)
+
+		for value := range input.values {
+			var errs []error
+			for _, validator := range validators {
+				if err := validator(input.ctx, value); err != nil {
+					errs = append(errs, err)
+				}
+			}
+
+			if len(errs) == 0 {
+				select {
+				case valid <- value:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			} else {
+				select {
+				case invalid <- Result[T]{Value: value, Err: errors.Join(errs...)}:
+				case <-input.ctx.Done():
+					return input.ctx.Err()
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: valid,
+	}, Pipeline[Result[T]]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: invalid,
+	}
+}
+
+// WithDeadline is a processing stage that passes values of type T through unchanged while imposing a deadline on the
+// remainder of the Pipeline. Subsequent stages run under a Context derived from the Pipeline's, whose deadline is the
+// earlier of any existing one and t. Preceding stages keep their original Context. Since all stages still share one
+// errgroup, the deadline expiring in any subsequent stage, or while waiting here for the next value, aborts the entire
+// Pipeline with context.DeadlineExceeded.
+func WithDeadline[T any](input Pipeline[T], t time.Time) Pipeline[T] {
+	ctx, cancel := context.WithDeadline(input.ctx, t)
+	output := make(chan T)
+
+	go func() {
+		<-input.ctx.Done()
+		cancel()
+	}()
+
+	input.run(func() error {
+		defer close(output)
+
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					return nil
+				}
+
+				select {
+				case output <- value:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// WithEndMarker is a processing stage that passes values of type T through unchanged and then, once the input is
+// exhausted, passes on the given marker, for consumers that cannot observe the end of the stream directly. The marker
+// is passed on even if the stream was empty, but not if the Pipeline has aborted.
+func WithEndMarker[T any](input Pipeline[T], marker T) Pipeline[T] {
+	return withEndMarker(input, marker, true)
+}
+
+// WithEndMarkerNonEmpty is identical to WithEndMarker except the marker is passed on only if at least one value was.
+func WithEndMarkerNonEmpty[T any](input Pipeline[T], marker T) Pipeline[T] {
+	return withEndMarker(input, marker, false)
+}
+
+// bloomFilter is a Bloom filter over 64-bit hashes, deriving each of its k bit positions by double hashing.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+// add sets the bits for the given hash and reports whether any of them was previously unset, meaning the hash is
+// certainly new.
+func (f *bloomFilter) add(h uint64) bool {
+	h1, h2 := h, (h>>33|h<<31)|1
+	added := false
+
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			added = true
+		}
+	}
+
+	return added
+}
+
+// breaker is the concurrency-safe state of a circuit breaker.
+type breaker struct {
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	lastErr  error
+	trial    bool
+}
+
+// allow returns nil if a call may go ahead, or an error wrapping ErrCircuitOpen if the breaker is open.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.settings.Threshold {
+		return nil
+	} else if b.trial || time.Since(b.openedAt) < b.settings.Cooldown {
+		return fmt.Errorf("%w: %w", ErrCircuitOpen, b.lastErr)
+	}
+
+	b.trial = true
+	return nil
+}
+
+// record notes the outcome of a call allowed by the breaker.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+
+	if b.failures >= b.settings.Threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// heapOf adapts a slice of type T to container/heap, ordered by the given less function.
+type heapOf[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func (h *heapOf[T]) Len() int {
+	return len(h.items)
+}
+
+func (h *heapOf[T]) Less(i, j int) bool {
+	return h.less(h.items[i], h.items[j])
+}
+
+func (h *heapOf[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func (h *heapOf[T]) Push(x any) {
+	h.items = append(h.items, x.(T))
+}
+
+func (h *heapOf[T]) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+// medianHeap adapts a slice of medianNodes to container/heap, ordered by the given less function. Each node's index is
+// kept up to date so that it can be removed wherever it lies.
+type medianHeap[T ~float64] struct {
+	nodes []*medianNode[T]
+	less  func(a, b T) bool
+}
+
+func (h *medianHeap[T]) Len() int {
+	return len(h.nodes)
+}
+
+func (h *medianHeap[T]) Less(i, j int) bool {
+	return h.less(h.nodes[i].value, h.nodes[j].value)
+}
+
+func (h *medianHeap[T]) Pop() any {
+	last := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return last
+}
+
+func (h *medianHeap[T]) Push(x any) {
+	node := x.(*medianNode[T])
+	node.index = len(h.nodes)
+	h.nodes = append(h.nodes, node)
+}
+
+func (h *medianHeap[T]) Swap(i, j int) {
+	h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i]
+	h.nodes[i].index = i
+	h.nodes[j].index = j
+}
+
+// medianNode is a value of type T within the window of MovingMedian, along with which of its two heaps holds it and
+// where.
+type medianNode[T ~float64] struct {
+	value T
+	lower bool
+	index int
+}
+
+// mergeCursor is the next value of type T waiting to be merged from the source at the given index.
+type mergeCursor[T any] struct {
+	value  T
+	source int
+}
+
+// partitionFile is a file opened by PartitionedFileSink, along with the buffer for writing to it and when it was last
+// written, as a count of values.
+type partitionFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	used   uint64
+}
+
+// sortedWindow holds the trailing window of values of type T both in order of arrival and in sorted order. Finding
+// where a value enters or leaves the sorted order is a binary search, but shifting the others to make room for it takes
+// time proportional to the window. It must not be given NaN, which cannot be found again by the search.
+type sortedWindow[T ~float64] struct {
+	ring   []T
+	sorted []T
+	oldest int
+}
+
+// add places a value into the window, displacing the oldest if it is full, and returns the values now held in sorted
+// order. The slice returned is only valid until the next call.
+func (w *sortedWindow[T]) add(value T) []T {
+	if len(w.ring) < cap(w.ring) {
+		w.ring = append(w.ring, value)
+	} else {
+		i := sort.Search(len(w.sorted), func(i int) bool {
+			return w.sorted[i] >= w.ring[w.oldest]
+		})
+		w.sorted = append(w.sorted[:i], w.sorted[i+1:]...)
+
+		w.ring[w.oldest] = value
+		w.oldest = (w.oldest + 1) % len(w.ring)
+	}
+
+	i := sort.Search(len(w.sorted), func(i int) bool {
+		return w.sorted[i] >= value
+	})
+	w.sorted = append(w.sorted, value)
+	copy(w.sorted[i+1:], w.sorted[i:])
+	w.sorted[i] = value
+
+	return w.sorted
+}
+
+// stageGroup is the errgroup shared by the stages of a Pipeline. It additionally keeps count of the outputs still
+// awaiting a terminal processing stage and holds the errgroup open until each has been claimed. This allows several
+// outputs of one errgroup to be consumed by separate terminals without the errgroup finishing before all have started.
+type stageGroup struct {
+	*errgroup.Group
+	parent context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending int
+	claimed chan struct{}
+}
+
+// claim records that one pending output has been taken up by its consumer.
+func (g *stageGroup) claim() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending > 0 {
+		g.pending--
+		if g.pending == 0 {
+			close(g.claimed)
+		}
+	}
+}
+
+// fork records that one more output awaits a consumer.
+func (g *stageGroup) fork() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending++
+}
+
+// wait claims an output and then blocks until every stage in the errgroup has finished, releasing its Context.
+func (g *stageGroup) wait() error {
+	g.claim()
+	defer g.cancel()
+
+	if err := g.Wait(); err != errStopped {
+		return err
+	}
+
+	return nil
+}
+
+// abort stands in for a processing stage given invalid arguments. It fails the input's errgroup with the given error and
+// returns an empty Pipeline in place of the stage's output.
+func abort[I, O any](input Pipeline[I], err error) Pipeline[O] {
+	output := make(chan O)
+	close(output)
+
+	input.run(func() error {
+		return err
+	})
+
+	return Pipeline[O]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// attach ties the errgroup of other to that of base so that a stage running in base's errgroup can consume other in
+// place of a terminal. If the errgroups differ, waiting on base then also waits on other, an error in other aborts
+// base, and cancelling base cancels other.
+func attach[A, B any](base Pipeline[A], other Pipeline[B]) {
+	if other.group == base.group {
+		other.group.claim()
+		return
+	}
+
+	base.group.Go(other.wait)
+
+	go func() {
+		<-base.ctx.Done()
+		other.group.cancel()
+	}()
+}
+
+// batch groups values of type T into slices of the given size, the last of which may be shorter.
+func batch[T any](input Pipeline[T], size int) Pipeline[[]T] {
+	output := make(chan []T)
+
+	input.run(func() error {
+		defer close(output)
+
+		values := make([]T, 0, size)
+		flush := func() error {
+			select {
+			case output <- values:
+				values = make([]T, 0, size)
+				return nil
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		for value := range input.values {
+			values = append(values, value)
+
+			if len(values) == size {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if len(values) > 0 {
+			return flush()
+		}
+
+		return nil
+	})
+
+	return Pipeline[[]T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// bufferBudget implements BufferBudget and BufferBudgetDrop, named by caller for the sake of errors.
+func bufferBudget[T any](input Pipeline[T], budget int, sizeOf func(T) int, drop bool, caller string) Pipeline[T] {
+	if budget < 1 {
+		return abort[T, T](input, fmt.Errorf("%s: budget must be at least 1, got %d", caller, budget))
+	}
+
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		var held []Pair[T, int]
+		var total int
+		in := input.values
+
+		for in != nil || len(held) > 0 {
+			var out chan T
+			var next T
+			if len(held) > 0 {
+				out = output
+				next = held[0].First
+			}
+
+			receive := in
+			if !drop && total >= budget && len(held) > 0 {
+				receive = nil
+			}
+
+			select {
+			case value, ok := <-receive:
+				if !ok {
+					in = nil
+					continue
+				}
+
+				size := sizeOf(value)
+				if drop && len(held) > 0 && total+size > budget {
+					continue
+				}
+
+				held = append(held, Pair[T, int]{value, size})
+				total += size
+
+			case out <- next:
+				total -= held[0].Second
+				held[0] = Pair[T, int]{}
+				held = held[1:]
+
+			case <-input.ctx.Done():
+				return input.ctx.Err()
+			}
+		}
+
+		return input.ctx.Err()
+	})
+
+	return Pipeline[T]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// circuitBreaker implements CircuitBreaker and CircuitBreakerFallback. Failed and rejected values are passed to the
+// given handler, which returns a replacement value and whether to emit it, or an error aborting the Pipeline.
+func circuitBreaker[I, O any](input Pipeline[I], settings BreakerSettings, mapper func(context.Context, I) (O, error), handler func(context.Context, I, error) (O, bool, error)) Pipeline[O] {
+	if settings.Threshold < 1 {
+		return abort[I, O](input, fmt.Errorf("CircuitBreaker: Threshold must be at least 1, got %d", settings.Threshold))
+	}
+
+	b := &breaker{settings: settings}
+	output := make(chan O)
+
+	input.run(func() error {
 		defer close(output)
 
 		for value := range input.values {
-			pass, err := filter(input.ctx, value)
+			var newValue O
+			emit := true
+
+			err := b.allow()
+			if err == nil {
+				newValue, err = mapper(input.ctx, value)
+				b.record(err)
+			}
+
 			if err != nil {
-				return err
-			} else if pass {
+				newValue, emit, err = handler(input.ctx, value, err)
+				if err != nil {
+					return err
+				}
+			}
+
+			if emit {
 				select {
-				case output <- value:
+				case output <- newValue:
 				case <-input.ctx.Done():
 					return input.ctx.Err()
 				}
@@ -37,50 +4850,120 @@ func Filter[T any](input Pipeline[T], filter func(context.Context, T) (bool, err
 		return nil
 	})
 
-	return Pipeline[T]{
+	return Pipeline[O]{
 		ctx:    input.ctx,
 		group:  input.group,
+		name:   input.name,
 		values: output,
 	}
 }
 
-// Flatten is a processing stage that collapses a sequence of slices of type T into a single slice of the same type.
-func Flatten[T any](input Pipeline[[]T]) Pipeline[T] {
+// cutoff implements StopWhen and TotalLimit. The step function decides for each value whether to pass it on and whether
+// to end the stream after it. Once ended, the output is closed and the input's errgroup canceled. The output belongs to a
+// new errgroup, so that canceling the input does not disturb the stages consuming it. Errors of its own are prefixed
+// with the Pipeline's name, as run would, while those of the input's errgroup already carry it.
+func cutoff[T any](input Pipeline[T], step func(context.Context, T) (pass, done bool, err error)) Pipeline[T] {
+	group, groupContext := newStageGroup(input.group.parent)
 	output := make(chan T)
 
-	input.group.Go(func() error {
-		for slice := range input.values {
-			for _, value := range slice {
-				select {
-				case output <- value:
-				case <-input.ctx.Done():
-					return input.ctx.Err()
+	group.Go(func() error {
+		// The output is closed before the input's errgroup is canceled, so later stages need not wait on it.
+		stop := func(err error) error {
+			close(output)
+			input.group.cancel()
+			_ = input.wait()
+			return err
+		}
+
+		for {
+			select {
+			case value, ok := <-input.values:
+				if !ok {
+					close(output)
+					return input.wait()
+				}
+
+				pass, done, err := step(groupContext, value)
+				if err != nil {
+					return stop(input.wrap(err))
+				}
+
+				if pass {
+					select {
+					case output <- value:
+					case <-groupContext.Done():
+						return stop(input.wrap(groupContext.Err()))
+					}
+				}
+
+				if done {
+					return stop(nil)
+				}
+
+			case <-groupContext.Done():
+				return stop(input.wrap(groupContext.Err()))
+			}
+		}
+	})
+
+	return Pipeline[T]{
+		ctx:    groupContext,
+		group:  group,
+		name:   input.name,
+		values: output,
+	}
+}
+
+// deref implements Deref and DerefStrict. A nil pointer fails the Pipeline with the given error, or is dropped if nil.
+func deref[T any](input Pipeline[*T], nilErr error) Pipeline[T] {
+	output := make(chan T)
+
+	input.run(func() error {
+		defer close(output)
+
+		for pointer := range input.values {
+			if pointer == nil {
+				if nilErr != nil {
+					return nilErr
 				}
+				continue
+			}
+
+			select {
+			case output <- *pointer:
+			case <-input.ctx.Done():
+				return input.ctx.Err()
 			}
 		}
 
-		close(output)
 		return nil
 	})
 
 	return Pipeline[T]{
 		ctx:    input.ctx,
 		group:  input.group,
+		name:   input.name,
 		values: output,
 	}
 }
 
-// Map is a processing stage that converts values of type I into values of type O using the given mapper function.
-func Map[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) Pipeline[O] {
+// mapSafe implements MapSafe and MapSafeSkip. A panic in the mapper function is passed to the given handler, whose
+// returned error, if any, fails the Pipeline. Otherwise the value is skipped.
+func mapSafe[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error), handler func(I, *PanicError) error) Pipeline[O] {
 	output := make(chan O)
 
-	input.group.Go(func() error {
+	input.run(func() error {
 		defer close(output)
 
 		for value := range input.values {
-			newValue, err := mapper(input.ctx, value)
+			newValue, panicErr, err := recoverMap(input.ctx, mapper, value)
 			if err != nil {
 				return err
+			} else if panicErr != nil {
+				if err := handler(value, panicErr); err != nil {
+					return err
+				}
+				continue
 			}
 
 			select {
@@ -96,168 +4979,241 @@ func Map[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)
 	return Pipeline[O]{
 		ctx:    input.ctx,
 		group:  input.group,
+		name:   input.name,
 		values: output,
 	}
 }
 
-// ParallelFilter is identical to Filter except the filtering operations are performed in parallel.
-// This process is not guaranteed to maintain the order of the values.
-func ParallelFilter[T any](input Pipeline[T], filter func(context.Context, T) (bool, error)) Pipeline[T] {
-	output := make(chan T)
+// newBloomFilter creates a bloomFilter sized to hold n hashes with the given false-positive rate.
+func newBloomFilter(n int, fpRate float64) *bloomFilter {
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
 
-	input.group.Go(func() error {
-		defer close(output)
-		filteringGroup, filteringContext := errgroup.WithContext(input.ctx)
+	return &bloomFilter{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		m:    uint64(m),
+		k:    k,
+	}
+}
 
-		for value := range input.values {
-			value := value
-			filteringGroup.Go(func() error {
-				pass, err := filter(input.ctx, value)
-				if err != nil {
-					return err
-				} else if pass {
-					select {
-					case output <- value:
-					case <-filteringContext.Done():
-						return filteringContext.Err()
-					}
-				}
+// newSortedWindow creates a sortedWindow holding up to the given number of values.
+func newSortedWindow[T ~float64](window int) *sortedWindow[T] {
+	return &sortedWindow[T]{ring: make([]T, 0, window), sorted: make([]T, 0, window)}
+}
 
-				return nil
-			})
-		}
+// newStageGroup creates a stageGroup and associated Context derived from the given one, with a single output pending.
+func newStageGroup(parent context.Context) (*stageGroup, context.Context) {
+	ctx, cancel := context.WithCancel(parent)
+	group, groupContext := errgroup.WithContext(ctx)
 
-		return filteringGroup.Wait()
+	g := &stageGroup{
+		Group:   group,
+		parent:  parent,
+		cancel:  cancel,
+		pending: 1,
+		claimed: make(chan struct{}),
+	}
+
+	g.Go(func() error {
+		<-g.claimed
+		return nil
 	})
 
-	return Pipeline[T]{
-		ctx:    input.ctx,
-		group:  input.group,
-		values: output,
+	return g, groupContext
+}
+
+// normalize scales value into the range 0 to 1 relative to low and high, giving 0 when the two are equal.
+func normalize[T ~float64](value, low, high T) float64 {
+	if high == low {
+		return 0
 	}
+
+	return float64((value - low) / (high - low))
 }
 
-// ParallelMap is identical to Map except the mapping operations are performed in parallel.
-// This process is not guaranteed to maintain the order of the values.
-func ParallelMap[I, O any](input Pipeline[I], mapper func(context.Context, I) (O, error)) Pipeline[O] {
+// pool runs the given process function on values of type I across a fixed number of workers. Each call may pass any
+// number of values of type O on through the supplied emit function. An error from any call aborts the Pipeline.
+func pool[I, O any](input Pipeline[I], workers int, process func(ctx context.Context, value I, emit func(O) error) error) Pipeline[O] {
 	output := make(chan O)
 
-	input.group.Go(func() error {
+	input.run(func() error {
 		defer close(output)
-		mappingGroup, mappingContext := errgroup.WithContext(input.ctx)
+		workerGroup, workerContext := errgroup.WithContext(input.ctx)
 
-		for value := range input.values {
-			value := value
-			mappingGroup.Go(func() error {
-				newValue, err := mapper(mappingContext, value)
-				if err != nil {
-					return err
-				}
+		emit := func(value O) error {
+			select {
+			case output <- value:
+				return nil
+			case <-workerContext.Done():
+				return workerContext.Err()
+			}
+		}
 
-				select {
-				case output <- newValue:
-					return nil
+		for i := 0; i < workers; i++ {
+			workerGroup.Go(func() error {
+				for {
+					select {
+					case value, ok := <-input.values:
+						if !ok {
+							return nil
+						}
 
-				case <-mappingContext.Done():
-					return mappingContext.Err()
+						if err := process(workerContext, value, emit); err != nil {
+							return err
+						}
+
+					case <-workerContext.Done():
+						return workerContext.Err()
+					}
 				}
 			})
 		}
 
-		return mappingGroup.Wait()
+		return workerGroup.Wait()
 	})
 
 	return Pipeline[O]{
 		ctx:    input.ctx,
 		group:  input.group,
+		name:   input.name,
 		values: output,
 	}
 }
 
-// Reduce is a terminal processing stage that consumes values of type I and reduces them down to a single value of type O
-// using the given reducer function, beginning with the given initial state.
-func Reduce[I, O any](input Pipeline[I], reducer func(context.Context, I, O) (O, error), initialState O) (O, error) {
-	currentState := initialState
+// rateMeter returns a function that records an arrival and gives the number of arrivals per second within the trailing
+// window of time. Memory is bounded by the number of arrivals within a window. The function is not safe for concurrent
+// use.
+func rateMeter(window time.Duration) func() float64 {
+	arrivals := make([]time.Time, 0)
 
-	input.group.Go(func() error {
-		for value := range input.values {
-			newState, err := reducer(input.ctx, value, currentState)
-			if err != nil {
-				return err
+	return func() float64 {
+		now := time.Now()
+
+		expired := 0
+		for _, at := range arrivals {
+			if now.Sub(at) < window {
+				break
 			}
+			expired++
+		}
+		arrivals = append(arrivals[expired:], now)
 
-			currentState = newState
+		return float64(len(arrivals)) / window.Seconds()
+	}
+}
+
+// recoverMap calls the given mapper function, converting a panic into a *PanicError.
+func recoverMap[I, O any](ctx context.Context, mapper func(context.Context, I) (O, error), value I) (newValue O, panicErr *PanicError, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr = &PanicError{Value: recovered, Stack: debug.Stack()}
 		}
+	}()
 
-		return nil
-	})
+	newValue, err = mapper(ctx, value)
+	return newValue, nil, err
+}
 
-	err := input.group.Wait()
-	return currentState, err
+// sleep pauses for the given duration or until the Context is done, whichever comes first. In the latter case the
+// Context's error is returned.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Sink is a terminal processing stage that consumes values of type T using the given sink function. Any error generated
-// by the Pipeline's errgroup will be returned here.
-func Sink[T any](input Pipeline[T], sink func(context.Context, T) error) error {
-	input.group.Go(func() error {
-		for {
-			select {
-			case value, ok := <-input.values:
-				if !ok {
-					return nil
-				}
-				if err := sink(input.ctx, value); err != nil {
-					return err
-				}
+// tuples implements the Tuples2 and Tuples3 stages, gathering n values at a time and combining them using the given
+// build function. If strict, leftover values fail the Pipeline with ErrIncompleteTuple rather than being dropped.
+func tuples[T, G any](input Pipeline[T], n int, strict bool, build func([]T) G) Pipeline[G] {
+	output := make(chan G)
+
+	input.run(func() error {
+		defer close(output)
+
+		values := make([]T, 0, n)
 
+		for value := range input.values {
+			if values = append(values, value); len(values) < n {
+				continue
+			}
+
+			select {
+			case output <- build(values):
 			case <-input.ctx.Done():
 				return input.ctx.Err()
 			}
-		}
-	})
 
-	return input.group.Wait()
-}
+			values = values[:0]
+		}
 
-// SliceSource is a helper function around Source that generates values from the given slice.
-func SliceSource[T any](ctx context.Context, slice []T) Pipeline[T] {
-	return Source(ctx, func(_ context.Context, emit func(T) error) error {
-		for _, value := range slice {
-			if err := emit(value); err != nil {
-				return err
-			}
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if strict && len(values) > 0 {
+			return ErrIncompleteTuple
 		}
 
 		return nil
 	})
+
+	return Pipeline[G]{
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
+		values: output,
+	}
 }
 
-// Source is a processing stage that generates values of type T using the given source function.
-// This and all subsequent stages will run within an errgroup created from the given Context.
-//
-// The channel passed to the generator function is automatically closed when the function returns.
-func Source[T any](ctx context.Context, source func(context.Context, func(T) error) error) Pipeline[T] {
-	group, groupContext := errgroup.WithContext(ctx)
+// withEndMarker implements WithEndMarker and WithEndMarkerNonEmpty, passing on the marker after an empty stream only if
+// always is set.
+func withEndMarker[T any](input Pipeline[T], marker T, always bool) Pipeline[T] {
 	output := make(chan T)
 
-	group.Go(func() error {
+	input.run(func() error {
 		defer close(output)
 
-		emit := func(value T) error {
+		empty := true
+
+		for value := range input.values {
+			empty = false
+
 			select {
 			case output <- value:
-				return nil
-			case <-groupContext.Done():
-				return groupContext.Err()
+			case <-input.ctx.Done():
+				return input.ctx.Err()
 			}
 		}
 
-		return source(groupContext, emit)
+		if err := input.ctx.Err(); err != nil {
+			return err
+		} else if empty && !always {
+			return nil
+		}
+
+		select {
+		case output <- marker:
+			return nil
+		case <-input.ctx.Done():
+			return input.ctx.Err()
+		}
 	})
 
 	return Pipeline[T]{
-		ctx:    groupContext,
-		group:  group,
+		ctx:    input.ctx,
+		group:  input.group,
+		name:   input.name,
 		values: output,
 	}
 }
@@ -1,29 +1,49 @@
 package fngo
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
-func TestParallelFilter(t *testing.T) {
-	expectedNames := map[string]any{
-		"alice":   true,
-		"charlie": true,
-		"darren":  true,
-	}
-
+func TestAdaptiveBatch(t *testing.T) {
 	tests := []struct {
-		name          string
-		filterError   error
-		cancelContext bool
-		expectedError error
+		name           string
+		minSize        int
+		maxSize        int
+		pace           time.Duration
+		delay          time.Duration
+		cancelContext  bool
+		expectedSizes  []int
+		expectedSteady int
+		expectedError  error
 	}{
-		{"nominal", nil, false, nil},
-		{"filterError", assert.AnError, false, assert.AnError},
-		{"masterContextCanceled", nil, true, context.Canceled},
+		{"nominal", 1, 4, 5 * time.Millisecond, 0, false, []int{1, 2, 4, 4, 4, 4, 1}, 0, nil},
+		{"slowConsumer", 2, 8, 0, 5 * time.Millisecond, false, nil, 2, nil},
+		{"invalidSizes", 4, 2, 0, 0, false, nil, 0, fmt.Errorf("AdaptiveBatch: need 1 <= minSize <= maxSize, got 4 and 2")},
+		{"masterContextCanceled", 1, 4, 0, 0, true, nil, 0, context.Canceled},
 	}
 
 	for _, test := range tests {
@@ -35,28 +55,59 @@ func TestParallelFilter(t *testing.T) {
 				cancel()
 			}
 
-			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+			numbers := make([]int, 20)
+			for i := range numbers {
+				numbers[i] = i
+			}
 
-			filteredNames := ParallelFilter(names, func(_ context.Context, name string) (bool, error) {
-				return strings.ContainsRune(name, 'a'), test.filterError
+			// Pacing the source leaves the consumer ample time to be waiting before each batch fills, so the
+			// batch size grows deterministically.
+			paced := Source(ctx, func(ctx context.Context, emit func(int) error) error {
+				for _, number := range numbers {
+					time.Sleep(test.pace)
+					if err := emit(number); err != nil {
+						return err
+					}
+				}
+				return nil
 			})
 
-			actualNames := make(map[string]any)
-			err := Sink(filteredNames, func(_ context.Context, name string) error {
-				actualNames[name] = true
+			batches := AdaptiveBatch(paced, test.minSize, test.maxSize)
+
+			var actualNumbers []int
+			var actualSizes []int
+			err := Sink(batches, func(_ context.Context, batch []int) error {
+				time.Sleep(test.delay)
+				actualNumbers = append(actualNumbers, batch...)
+				actualSizes = append(actualSizes, len(batch))
 				return nil
 			})
 
 			assert.Equal(t, test.expectedError, err, "wrong error")
 
 			if test.expectedError == nil {
-				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, numbers, actualNumbers, "wrong numbers")
+				for _, size := range actualSizes {
+					assert.LessOrEqual(t, size, test.maxSize, "batch too large")
+				}
+				if test.expectedSizes != nil {
+					assert.Equal(t, test.expectedSizes, actualSizes, "wrong sizes")
+				}
+
+				// Only the first batch can be taken up immediately by a slow consumer, so every batch from the
+				// third on must have shrunk back to the minimum.
+				if test.expectedSteady > 0 {
+					assert.Greater(t, len(actualSizes), 2, "too few batches")
+					for _, size := range actualSizes[2:] {
+						assert.Equal(t, test.expectedSteady, size, "batch not shrunk")
+					}
+				}
 			}
 		})
 	}
 }
 
-func TestParallelMap(t *testing.T) {
+func TestAutoParallelMap(t *testing.T) {
 	expectedLengths := map[int]any{
 		3: true,
 		4: true,
@@ -67,13 +118,14 @@ func TestParallelMap(t *testing.T) {
 
 	tests := []struct {
 		name          string
+		minW, maxW    int
 		mapError      error
 		cancelContext bool
 		expectedError error
 	}{
-		{"nominal", nil, false, nil},
-		{"mapError", assert.AnError, false, assert.AnError},
-		{"masterContextCanceled", nil, true, context.Canceled},
+		{"nominal", 1, 3, nil, false, nil},
+		{"mapError", 1, 3, assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", 1, 3, nil, true, context.Canceled},
 	}
 
 	for _, test := range tests {
@@ -87,7 +139,23 @@ func TestParallelMap(t *testing.T) {
 
 			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
 
-			mappedLengths := ParallelMap(names, func(_ context.Context, name string) (int, error) {
+			var mu sync.Mutex
+			active, maxActive := 0, 0
+
+			mappedLengths := AutoParallelMap(names, test.minW, test.maxW, func(_ context.Context, name string) (int, error) {
+				mu.Lock()
+				active++
+				if active > maxActive {
+					maxActive = active
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				active--
+				mu.Unlock()
+
 				return len(name), test.mapError
 			})
 
@@ -101,47 +169,68 @@ func TestParallelMap(t *testing.T) {
 
 			if test.expectedError == nil {
 				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+				assert.LessOrEqual(t, maxActive, test.maxW, "too many workers")
+				assert.Greater(t, maxActive, test.minW, "workers never scaled up")
 			}
 		})
 	}
 }
 
-func TestPipeline(t *testing.T) {
-	expectedNames := []string{
-		"alice",
-		"alice",
-		"alice",
-		"alice",
-		"alice",
-		"charlie",
-		"charlie",
-		"charlie",
-		"charlie",
-		"charlie",
-		"charlie",
-		"charlie",
-		"david",
-		"david",
-		"david",
-		"david",
-		"david",
-	}
-
+func TestBatchChannelSource(t *testing.T) {
 	tests := []struct {
 		name          string
-		sourceError   error
-		filterError   error
-		mapError      error
-		sinkError     error
 		cancelContext bool
+		expectedNames []string
 		expectedError error
 	}{
-		{"nominal", nil, nil, nil, nil, false, nil},
-		{"sourceError", assert.AnError, nil, nil, nil, false, assert.AnError},
-		{"filterError", nil, assert.AnError, nil, nil, false, assert.AnError},
-		{"mapError", nil, nil, assert.AnError, nil, false, assert.AnError},
-		{"sinkError", nil, nil, nil, assert.AnError, false, assert.AnError},
-		{"masterContextCanceled", nil, nil, nil, nil, true, context.Canceled},
+		{"nominal", false, []string{"alice", "bob", "charlie"}, nil},
+		{"masterContextCanceled", true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			ch := make(chan []string, 3)
+			ch <- []string{"alice", "bob"}
+			ch <- nil
+			ch <- []string{"charlie"}
+			close(ch)
+
+			var actualNames []string
+			err := Sink(BatchChannelSource(ctx, ch), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestBufferBudget(t *testing.T) {
+	tests := []struct {
+		name            string
+		budget          int
+		drop            bool
+		cancelContext   bool
+		expectedEmitted int32
+		expectedNames   []string
+		expectedError   error
+	}{
+		{"nominal", 8, false, false, 2, []string{"alice", "bob", "charlie", "darren", "erin"}, nil},
+		{"drop", 8, true, false, 5, []string{"alice", "bob"}, nil},
+		{"invalidBudget", 0, false, false, 0, nil, fmt.Errorf("BufferBudget: budget must be at least 1, got 0")},
+		{"masterContextCanceled", 8, false, true, 0, nil, context.Canceled},
 	}
 
 	for _, test := range tests {
@@ -153,110 +242,5693 @@ func TestPipeline(t *testing.T) {
 				cancel()
 			}
 
+			var emitted atomic.Int32
 			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
-				for _, name := range []string{"alice", "bob", "charlie", "david", "erin"} {
+				for _, name := range []string{"alice", "bob", "charlie", "darren", "erin"} {
 					if err := emit(name); err != nil {
 						return err
 					}
+					emitted.Add(1)
 				}
-
-				return test.sourceError
+				return nil
 			})
 
-			namesWithA := Filter(names, func(_ context.Context, name string) (bool, error) {
-				return strings.ContainsRune(name, 'a'), test.filterError
+			sizeOf := func(name string) int {
+				return len(name)
+			}
+
+			var buffered Pipeline[string]
+			if test.drop {
+				buffered = BufferBudgetDrop(names, test.budget, sizeOf)
+			} else {
+				buffered = BufferBudget(names, test.budget, sizeOf)
+			}
+
+			if test.expectedError == nil {
+				time.Sleep(20 * time.Millisecond)
+				assert.Equal(t, test.expectedEmitted, emitted.Load(), "wrong number emitted before consumption")
+			}
+
+			var actualNames []string
+			err := Sink(buffered, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
 			})
 
-			repeatedNames := Map(namesWithA, func(_ context.Context, name string) ([]string, error) {
-				output := make([]string, len(name))
+			assert.Equal(t, test.expectedError, err, "wrong error")
 
-				for i := range output {
-					output[i] = name
-				}
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
 
-				return output, test.mapError
+func TestCatch(t *testing.T) {
+	tests := []struct {
+		name            string
+		sourceError     error
+		sinkError       error
+		cancelContext   bool
+		expectedResults []Result[string]
+		expectedError   error
+	}{
+		{"nominal", nil, nil, false, []Result[string]{{Value: "alice"}, {Value: "bob"}}, nil},
+		{"sourceError", assert.AnError, nil, false, []Result[string]{{Value: "alice"}, {Value: "bob"}, {Err: assert.AnError}}, nil},
+		{"sinkError", nil, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", assert.AnError, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "bob"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+				return test.sourceError
 			})
 
-			flattenedNames := Flatten(repeatedNames)
+			results := Catch(names)
 
-			actualNames := make([]string, 0)
-			err := Sink(flattenedNames, func(_ context.Context, name string) error {
-				actualNames = append(actualNames, name)
+			var actualResults []Result[string]
+			err := Sink(results, func(_ context.Context, result Result[string]) error {
+				actualResults = append(actualResults, result)
 				return test.sinkError
 			})
 
 			assert.Equal(t, test.expectedError, err, "wrong error")
 
 			if test.expectedError == nil {
-				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, test.expectedResults, actualResults, "wrong results")
 			}
 		})
 	}
 }
 
-func TestReduce(t *testing.T) {
-	const expectedTotalLength = 24
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		name             string
+		readings         []int
+		cancelContext    bool
+		expectedReadings []int
+		expectedError    error
+	}{
+		{"nominal", []int{1, 1, 2, 2, 2, 1, 3, 3}, false, []int{1, 2, 1, 3}, nil},
+		{"zeroFirst", []int{0, 0, 1}, false, []int{0, 1}, nil},
+		{"empty", nil, false, nil, nil},
+		{"masterContextCanceled", []int{1, 2}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
 
+			if test.cancelContext {
+				cancel()
+			}
+
+			readings := SliceSource(ctx, test.readings)
+
+			var actualReadings []int
+			err := Sink(Changes(readings), func(_ context.Context, reading int) error {
+				actualReadings = append(actualReadings, reading)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedReadings, actualReadings, "wrong readings")
+			}
+		})
+	}
+}
+
+func TestChangesBy(t *testing.T) {
 	tests := []struct {
 		name          string
-		reduceError   error
+		keyError      error
+		expectedNames []string
 		expectedError error
 	}{
-		{"nominal", nil, nil},
-		{"reduceError", assert.AnError, assert.AnError},
+		{"nominal", nil, []string{"alice", "bob", "anna"}, nil},
+		{"keyError", assert.AnError, nil, assert.AnError},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
+			names := SliceSource(context.Background(), []string{"alice", "adam", "bob", "barbara", "anna"})
 
-			actualTotalLength, err := Reduce(names, func(_ context.Context, name string, currentTotal int) (int, error) {
-				return currentTotal + len(name), test.reduceError
-			}, 0)
+			changes := ChangesBy(names, func(_ context.Context, name string) (byte, error) {
+				return name[0], test.keyError
+			})
+
+			var actualNames []string
+			err := Sink(changes, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
 
 			assert.Equal(t, test.expectedError, err, "wrong error")
 
 			if test.expectedError == nil {
-				assert.Equal(t, expectedTotalLength, actualTotalLength, "wrong total-length")
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
 			}
 		})
 	}
 }
 
-func TestSliceSource(t *testing.T) {
-	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
-
+func TestCircuitBreaker(t *testing.T) {
 	tests := []struct {
-		name          string
-		cancelContext bool
-		expectedError error
+		name            string
+		failing         []string
+		expectedLengths []int
+		expectedError   error
 	}{
-		{"nominal", false, nil},
-		{"masterContextCanceled", true, context.Canceled},
+		{"nominal", nil, []int{5, 3, 7, 5, 4}, nil},
+		{"toleratedFailure", []string{"bob"}, []int{5, 7, 5, 4}, nil},
+		{"tripped", []string{"bob", "charlie"}, nil, ErrCircuitOpen},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
 
-			if test.cancelContext {
-				cancel()
+			settings := BreakerSettings{Threshold: 2, Cooldown: time.Minute}
+			lengths := CircuitBreaker(names, settings, func(_ context.Context, name string) (int, error) {
+				for _, failing := range test.failing {
+					if name == failing {
+						return 0, assert.AnError
+					}
+				}
+
+				return len(name), nil
+			})
+
+			actualLengths := make([]int, 0)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			assert.ErrorIs(t, err, test.expectedError, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
+			} else {
+				assert.ErrorIs(t, err, assert.AnError, "missing cause")
 			}
+		})
+	}
+}
 
-			names := SliceSource(ctx, expectedNames)
+func TestCircuitBreakerFallback(t *testing.T) {
+	tests := []struct {
+		name            string
+		cooldown        time.Duration
+		fallbackError   error
+		expectedLengths []int
+		expectedError   error
+	}{
+		{"open", time.Minute, nil, []int{5, -1, -1, -2, -2}, nil},
+		{"halfOpen", 0, nil, []int{5, -1, -1, 5, 4}, nil},
+		{"fallbackError", time.Minute, assert.AnError, nil, assert.AnError},
+	}
 
-			actualNames := make([]string, 0)
-			err := Sink(names, func(_ context.Context, name string) error {
-				actualNames = append(actualNames, name)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
+
+			settings := BreakerSettings{Threshold: 2, Cooldown: test.cooldown}
+			lengths := CircuitBreakerFallback(names, settings, func(_ context.Context, name string) (int, error) {
+				if name == "bob" || name == "charlie" {
+					return 0, errors.New("unavailable")
+				}
+
+				return len(name), nil
+			}, func(_ context.Context, _ string, err error) (int, error) {
+				if errors.Is(err, ErrCircuitOpen) {
+					return -2, test.fallbackError
+				}
+
+				return -1, test.fallbackError
+			})
+
+			actualLengths := make([]int, 0)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
 				return nil
 			})
 
 			assert.Equal(t, test.expectedError, err, "wrong error")
 
 			if test.expectedError == nil {
-				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
 			}
 		})
 	}
 }
+
+func TestCollectSorted(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		sourceError   error
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", []string{"charlie", "alice", "bob"}, nil, []string{"alice", "bob", "charlie"}, nil},
+		{"empty", nil, nil, []string{}, nil},
+		{"sourceError", []string{"charlie", "alice"}, assert.AnError, []string{"alice", "charlie"}, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+				for _, name := range test.names {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+				return test.sourceError
+			})
+
+			actualNames, err := CollectSorted(names, func(a, b string) bool {
+				return a < b
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+		})
+	}
+}
+
+func TestCollectTimed(t *testing.T) {
+	tests := []struct {
+		name          string
+		sourceError   error
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", nil, []string{"alice", "bob"}, nil},
+		{"sourceError", assert.AnError, []string{"alice", "bob"}, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "bob"} {
+					time.Sleep(10 * time.Millisecond)
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+				return test.sourceError
+			})
+
+			actualNames, elapsed, err := CollectTimed(names)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			assert.GreaterOrEqual(t, elapsed, 15*time.Millisecond, "wrong elapsed time")
+		})
+	}
+}
+
+func TestCountWindow(t *testing.T) {
+	tests := []struct {
+		name           string
+		window         time.Duration
+		pause          time.Duration
+		expectedCounts []Count[string]
+		expectedError  error
+	}{
+		{"nominal", time.Minute, 0, []Count[string]{{"alice", 1}, {"alice", 2}, {"bob", 1}, {"alice", 3}}, nil},
+		{"expired", 10 * time.Millisecond, 20 * time.Millisecond, []Count[string]{{"alice", 1}, {"alice", 2}, {"bob", 1}, {"alice", 1}}, nil},
+		{"invalidWindow", 0, 0, []Count[string]{}, fmt.Errorf("CountWindow: window must be positive, got 0s")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "alice", "bob"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+
+				time.Sleep(test.pause)
+				return emit("alice")
+			})
+
+			actualCounts := make([]Count[string], 0)
+			err := Sink(CountWindow(names, test.window), func(_ context.Context, count Count[string]) error {
+				actualCounts = append(actualCounts, count)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedCounts, actualCounts, "wrong counts")
+		})
+	}
+
+	t.Run("sliding", func(t *testing.T) {
+		// Each arrival is forgotten once the window has passed, while the more recent one is still counted.
+		names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+			for i := 0; i < 3; i++ {
+				if i > 0 {
+					time.Sleep(120 * time.Millisecond)
+				}
+				if err := emit("alice"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		actualCounts := make([]Count[string], 0)
+		err := Sink(CountWindow(names, 200*time.Millisecond), func(_ context.Context, count Count[string]) error {
+			actualCounts = append(actualCounts, count)
+			return nil
+		})
+
+		assert.NoError(t, err, "wrong error")
+		assert.Equal(t, []Count[string]{{"alice", 1}, {"alice", 2}, {"alice", 2}}, actualCounts, "wrong counts")
+	})
+}
+
+func TestDelimitedSource(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		readError       error
+		cancelContext   bool
+		expectedRecords []string
+		expectedError   error
+	}{
+		{"nominal", "alice\x00bob\x00\x00charlie\x00", nil, false, []string{"alice", "bob", "", "charlie"}, nil},
+		{"unterminated", "alice\x00bob", nil, false, []string{"alice", "bob"}, nil},
+		{"empty", "", nil, false, nil, nil},
+		{"readError", "alice\x00bob", assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", "alice\x00", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var r io.Reader = strings.NewReader(test.input)
+			if test.readError != nil {
+				r = io.MultiReader(r, testFailingReader{test.readError})
+			}
+
+			var actualRecords []string
+			err := Sink(DelimitedSource(ctx, r, 0), func(_ context.Context, record []byte) error {
+				actualRecords = append(actualRecords, string(record))
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedRecords, actualRecords, "wrong records")
+			}
+		})
+	}
+}
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		name           string
+		totals         []int
+		cancelContext  bool
+		expectedDeltas []int
+		expectedError  error
+	}{
+		{"nominal", []int{10, 15, 15, 30, 28}, false, []int{5, 0, 15, -2}, nil},
+		{"single", []int{10}, false, nil, nil},
+		{"masterContextCanceled", []int{10, 15}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			totals := SliceSource(ctx, test.totals)
+
+			var actualDeltas []int
+			err := Sink(Delta(totals), func(_ context.Context, delta int) error {
+				actualDeltas = append(actualDeltas, delta)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedDeltas, actualDeltas, "wrong deltas")
+			}
+		})
+	}
+}
+
+func TestDeltaBy(t *testing.T) {
+	start := time.Now()
+	times := SliceSource(context.Background(), []time.Time{start, start.Add(time.Second), start.Add(3 * time.Second)})
+
+	gaps := DeltaBy(times, func(current, previous time.Time) time.Time {
+		return start.Add(current.Sub(previous))
+	})
+
+	var actualGaps []time.Duration
+	err := Sink(gaps, func(_ context.Context, gap time.Time) error {
+		actualGaps = append(actualGaps, gap.Sub(start))
+		return nil
+	})
+
+	assert.NoError(t, err, "wrong error")
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, actualGaps, "wrong gaps")
+}
+
+func TestDeref(t *testing.T) {
+	alice, bob := "alice", "bob"
+	expectedNames := []string{"alice", "bob"}
+
+	tests := []struct {
+		name          string
+		strict        bool
+		expectedError error
+	}{
+		{"nominal", false, nil},
+		{"strict", true, ErrNilPointer},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pointers := SliceSource(context.Background(), []*string{&alice, nil, &bob})
+
+			deref := Deref[string]
+			if test.strict {
+				deref = DerefStrict[string]
+			}
+
+			actualNames := make([]string, 0)
+			err := Sink(deref(pointers), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestDirWatchSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		poll          time.Duration
+		missingDir    bool
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 5 * time.Millisecond, false, false, context.Canceled},
+		{"invalidPoll", 0, false, false, fmt.Errorf("DirWatchSource: poll must be positive, got 0s")},
+		{"missingDir", 5 * time.Millisecond, true, false, os.ErrNotExist},
+		{"masterContextCanceled", 5 * time.Millisecond, false, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			dir := t.TempDir()
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "alice"), nil, 0o644), "wrong error")
+			assert.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o755), "wrong error")
+			if test.missingDir {
+				dir = filepath.Join(dir, "missing")
+			}
+
+			paths := DirWatchSource(ctx, dir, test.poll)
+
+			var actualPaths []string
+			err := Sink(paths, func(_ context.Context, path string) error {
+				actualPaths = append(actualPaths, path)
+
+				switch filepath.Base(path) {
+				case "alice":
+					return os.WriteFile(filepath.Join(dir, "bob"), nil, 0o644)
+				case "bob":
+					cancel()
+				}
+				return nil
+			})
+
+			if test.poll <= 0 {
+				assert.Equal(t, test.expectedError, err, "wrong error")
+			} else {
+				assert.ErrorIs(t, err, test.expectedError, "wrong error")
+			}
+
+			if test.poll > 0 && !test.missingDir && !test.cancelContext {
+				assert.Equal(t, []string{filepath.Join(dir, "alice"), filepath.Join(dir, "bob")}, actualPaths, "wrong paths")
+			}
+		})
+	}
+}
+
+func TestDistinctApprox(t *testing.T) {
+	tests := []struct {
+		name          string
+		expectedN     int
+		fpRate        float64
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 100, 0.01, false, []string{"alice", "bob", "charlie"}, nil},
+		{"invalidRate", 100, 1, false, nil, fmt.Errorf("DistinctApprox: need expectedN >= 1 and 0 < fpRate < 1, got 100 and 1")},
+		{"masterContextCanceled", 100, 0.01, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "alice", "charlie", "bob", "alice"})
+
+			distinct := DistinctApprox(names, func(name string) uint64 {
+				h := fnv.New64a()
+				h.Write([]byte(name))
+				return h.Sum64()
+			}, test.expectedN, test.fpRate)
+
+			var actualNames []string
+			err := Sink(distinct, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+
+	t.Run("falsePositiveRate", func(t *testing.T) {
+		const n = 10000
+
+		numbers := make([]uint64, n)
+		for i := range numbers {
+			numbers[i] = uint64(i)
+		}
+
+		count, err := Reduce(DistinctApprox(SliceSource(context.Background(), numbers), func(n uint64) uint64 {
+			h := fnv.New64a()
+			binary.Write(h, binary.LittleEndian, n)
+			return h.Sum64()
+		}, n, 0.01), func(_ context.Context, _ uint64, count int) (int, error) {
+			return count + 1, nil
+		}, 0)
+
+		assert.NoError(t, err, "wrong error")
+		assert.Greater(t, count, n*97/100, "too many values dropped")
+	})
+}
+
+func TestDrainN(t *testing.T) {
+	tests := []struct {
+		name             string
+		n                int
+		cancelContext    bool
+		expectedConsumed int
+		expectedError    error
+	}{
+		{"nominal", 2, false, 2, nil},
+		{"fewerValues", 5, false, 3, nil},
+		{"zero", 0, false, 0, nil},
+		{"masterContextCanceled", 2, true, 0, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+
+			actualConsumed, err := DrainN(names, test.n)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedConsumed, actualConsumed, "wrong number consumed")
+			}
+		})
+	}
+}
+
+func TestDropInvalid(t *testing.T) {
+	tests := []struct {
+		name            string
+		validError      error
+		expectedNames   []string
+		expectedDropped []string
+		expectedError   error
+	}{
+		{"nominal", nil, []string{"alice", "charlie"}, []string{"bob", "erin"}, nil},
+		{"validError", assert.AnError, nil, nil, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "erin"})
+
+			var actualDropped []string
+			valid := DropInvalid(names, func(_ context.Context, name string) (bool, error) {
+				return strings.Contains(name, "a"), test.validError
+			}, func(name string) {
+				actualDropped = append(actualDropped, name)
+			})
+
+			var actualNames []string
+			err := Sink(valid, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedDropped, actualDropped, "wrong dropped names")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	tests := []struct {
+		name            string
+		expandError     error
+		cancelContext   bool
+		expectedNumbers []int
+		expectedError   error
+	}{
+		{"nominal", nil, false, []int{1, 2, 3, 10, 11, 20}, nil},
+		{"expandError", assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			ranges := SliceSource(ctx, []Pair[int, int]{{1, 3}, {10, 11}, {5, 4}, {20, 20}})
+
+			numbers := ExpandRange(ranges, func(_ context.Context, r Pair[int, int], emit func(int) error) error {
+				for n := r.First; n <= r.Second; n++ {
+					if err := emit(n); err != nil {
+						return err
+					}
+				}
+				return test.expandError
+			})
+
+			var actualNumbers []int
+			err := Sink(numbers, func(_ context.Context, n int) error {
+				actualNumbers = append(actualNumbers, n)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNumbers, actualNumbers, "wrong numbers")
+			}
+		})
+	}
+}
+
+func TestExponentialSmooth(t *testing.T) {
+	tests := []struct {
+		name             string
+		alpha            float64
+		cancelContext    bool
+		expectedAverages []float64
+		expectedError    error
+	}{
+		{"nominal", 0.5, false, []float64{10, 15, 12.5, 16.25}, nil},
+		{"unsmoothed", 1, false, []float64{10, 20, 10, 20}, nil},
+		{"invalidAlpha", 0, false, nil, fmt.Errorf("ExponentialSmooth: need 0 < alpha <= 1, got 0")},
+		{"masterContextCanceled", 0.5, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			readings := SliceSource(ctx, []float64{10, 20, 10, 20})
+
+			var actualAverages []float64
+			err := Sink(ExponentialSmooth(readings, test.alpha), func(_ context.Context, average float64) error {
+				actualAverages = append(actualAverages, average)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedAverages, actualAverages, "wrong averages")
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		prefix        string
+		writeError    error
+		cancelContext bool
+		expectedFiles map[string]string
+		expectedError error
+	}{
+		{"nominal", "", nil, false, map[string]string{"a.txt": "anna", "b.txt": "bob"}, nil},
+		{"writeError", "", assert.AnError, false, map[string]string{}, assert.AnError},
+		{"escapingName", "../", nil, false, map[string]string{}, fmt.Errorf("FileSink: name %q leaves the directory", "../a.txt")},
+		{"masterContextCanceled", "", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "names")
+			names := SliceSource(ctx, []string{"alice", "bob", "anna"})
+
+			err := FileSink(names, dir, func(name string) string {
+				return test.prefix + name[:1] + ".txt"
+			}, func(w io.Writer, name string) error {
+				if _, err := io.WriteString(w, name); err != nil {
+					return err
+				}
+				return test.writeError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedFiles != nil {
+				entries, err := os.ReadDir(dir)
+				assert.NoError(t, err, "wrong error")
+
+				actualFiles := make(map[string]string)
+				for _, entry := range entries {
+					content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+					assert.NoError(t, err, "wrong error")
+					actualFiles[entry.Name()] = string(content)
+				}
+
+				assert.Equal(t, test.expectedFiles, actualFiles, "wrong files")
+			}
+
+			assert.NoFileExists(t, filepath.Join(root, "a.txt"), "file written outside directory")
+		})
+	}
+}
+
+func TestFindGaps(t *testing.T) {
+	tests := []struct {
+		name          string
+		ids           []int64
+		cancelContext bool
+		expectedGaps  []Gap
+		expectedError error
+	}{
+		{"nominal", []int64{1, 2, 5, 6, 8}, false, []Gap{{3, 4}, {7, 7}}, nil},
+		{"noGaps", []int64{3, 4, 5}, false, nil, nil},
+		{"duplicates", []int64{1, 1, 2, 2, 4}, false, []Gap{{3, 3}}, nil},
+		{"outOfOrder", []int64{1, 4, 2, 3, 6}, false, []Gap{{2, 3}, {5, 5}}, nil},
+		{"empty", nil, false, nil, nil},
+		{"masterContextCanceled", []int64{1, 3}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			ids := SliceSource(ctx, test.ids)
+			gaps := FindGaps(ids, func(id int64) int64 {
+				return id
+			})
+
+			var actualGaps []Gap
+			err := Sink(gaps, func(_ context.Context, gap Gap) error {
+				actualGaps = append(actualGaps, gap)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedGaps, actualGaps, "wrong gaps")
+			}
+		})
+	}
+}
+
+func TestFlatMapParallel(t *testing.T) {
+	expectedLetters := map[rune]int{
+		'a': 1,
+		'b': 2,
+		'c': 1,
+		'e': 1,
+		'h': 1,
+		'i': 1,
+		'l': 1,
+		'o': 1,
+		'r': 1,
+	}
+
+	tests := []struct {
+		name          string
+		mapError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"mapError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"bob", "charlie", ""})
+
+			letters := FlatMapParallel(names, 2, func(_ context.Context, name string) ([]rune, error) {
+				return []rune(name), test.mapError
+			})
+
+			actualLetters := make(map[rune]int)
+			err := Sink(letters, func(_ context.Context, letter rune) error {
+				actualLetters[letter]++
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLetters, actualLetters, "wrong letters")
+			}
+		})
+	}
+}
+
+func TestFlattenN(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", false, nil},
+		{"masterContextCanceled", true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			nested := SliceSource(ctx, [][][]string{{{"alice"}, {}, {"bob", "charlie"}}, {}, {{"david", "erin"}}})
+
+			actualNames := make([]string, 0)
+			err := Sink(FlattenN(nested), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestFlattenWithMarkers(t *testing.T) {
+	expectedItems := []FlatItem[string]{
+		{"alice", false, false},
+		{"bob", true, false},
+		{"", true, true},
+		{"charlie", true, false},
+	}
+
+	tests := []struct {
+		name          string
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", false, nil},
+		{"masterContextCanceled", true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			batches := SliceSource(ctx, [][]string{{"alice", "bob"}, {}, {"charlie"}})
+
+			actualItems := make([]FlatItem[string], 0)
+			err := Sink(FlattenWithMarkers(batches), func(_ context.Context, item FlatItem[string]) error {
+				actualItems = append(actualItems, item)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedItems, actualItems, "wrong items")
+			}
+		})
+	}
+}
+
+func TestFrameBytes(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		frameSize      int
+		overlap        int
+		cancelContext  bool
+		expectedFrames []string
+		expectedError  error
+	}{
+		{"nominal", "abcdefgh", 4, 2, false, []string{"abcd", "cdef", "efgh"}, nil},
+		{"shortFinal", "abcdefg", 4, 2, false, []string{"abcd", "cdef", "efg"}, nil},
+		{"noOverlap", "abcdefg", 3, 0, false, []string{"abc", "def", "g"}, nil},
+		{"shorterThanOverlap", "ab", 4, 3, false, []string{"ab"}, nil},
+		{"invalidOverlap", "abcd", 2, 2, false, nil, fmt.Errorf("FrameBytes: need 0 <= overlap < frameSize, got 2 and 2")},
+		{"masterContextCanceled", "abcd", 2, 1, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			stream := SliceSource(ctx, []byte(test.input))
+
+			var actualFrames []string
+			err := Sink(FrameBytes(stream, test.frameSize, test.overlap), func(_ context.Context, frame []byte) error {
+				actualFrames = append(actualFrames, string(frame))
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedFrames, actualFrames, "wrong frames")
+			}
+		})
+	}
+}
+
+func TestGobSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		writeError    error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"writeError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var buffer bytes.Buffer
+			var w io.Writer = &buffer
+			if test.writeError != nil {
+				w = testFailingWriter{test.writeError}
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+			err := GobSink(names, w)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				actualNames, err := TakeSlice(GobSource[string](context.Background(), &buffer), 10)
+				assert.NoError(t, err, "wrong error")
+				assert.Equal(t, []string{"alice", "bob", "charlie"}, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestGobSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		truncate      bool
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", false, false, []string{"alice", "bob", "charlie"}, nil},
+		{"truncated", true, false, nil, io.ErrUnexpectedEOF},
+		{"masterContextCanceled", false, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var buffer bytes.Buffer
+			encoder := gob.NewEncoder(&buffer)
+			for _, name := range []string{"alice", "bob", "charlie"} {
+				assert.NoError(t, encoder.Encode(name), "wrong error")
+			}
+			if test.truncate {
+				buffer.Truncate(buffer.Len() - 2)
+			}
+
+			var actualNames []string
+			err := Sink(GobSource[string](ctx, &buffer), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	expectedNames := []string{"alice", "bob"}
+
+	tests := []struct {
+		name          string
+		interval      time.Duration
+		pause         time.Duration
+		expectBeats   bool
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 10 * time.Millisecond, 0, false, false, nil},
+		{"idle", 10 * time.Millisecond, 50 * time.Millisecond, true, false, nil},
+		{"invalidInterval", 0, 0, false, false, fmt.Errorf("Heartbeat: interval must be positive, got 0s")},
+		{"masterContextCanceled", 10 * time.Millisecond, 0, false, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				if err := emit("alice"); err != nil {
+					return err
+				}
+
+				time.Sleep(test.pause)
+				return emit("bob")
+			})
+
+			var beats atomic.Int32
+			monitored := Heartbeat(names, test.interval, func() {
+				beats.Add(1)
+			})
+
+			actualNames := make([]string, 0)
+			err := Sink(monitored, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, test.expectBeats, beats.Load() > 1, "wrong beats")
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		pause         time.Duration
+		timeout       time.Duration
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 0, 50 * time.Millisecond, false, []string{"alice", "bob"}, nil},
+		{"idle", 100 * time.Millisecond, 20 * time.Millisecond, false, nil, ErrIdleTimeout},
+		{"invalidTimeout", 0, 0, false, nil, fmt.Errorf("IdleTimeout: d must be positive, got 0s")},
+		{"masterContextCanceled", 0, 50 * time.Millisecond, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(ctx context.Context, emit func(string) error) error {
+				if err := emit("alice"); err != nil {
+					return err
+				}
+
+				if err := sleep(ctx, test.pause); err != nil {
+					return err
+				}
+				return emit("bob")
+			})
+
+			var actualNames []string
+			err := Sink(IdleTimeout(names, test.timeout), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				time.Sleep(2 * test.timeout)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestIndexOf(t *testing.T) {
+	tests := []struct {
+		name          string
+		letter        string
+		predError     error
+		cancelContext bool
+		expectedIndex int
+		expectedError error
+	}{
+		{"nominal", "r", nil, false, 2, nil},
+		{"noMatch", "z", nil, false, -1, nil},
+		{"predError", "r", assert.AnError, false, -1, assert.AnError},
+		{"masterContextCanceled", "r", nil, true, -1, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+
+			actualIndex, err := IndexOf(names, func(_ context.Context, name string) (bool, error) {
+				return strings.Contains(name, test.letter), test.predError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedIndex, actualIndex, "wrong index")
+		})
+	}
+}
+
+func TestInterleaveRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		pRatio        int
+		sRatio        int
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 2, 1, false, []string{"alice", "bob", "X", "charlie", "darren", "Y", "erin", "Z", "W"}, nil},
+		{"secondaryHeavy", 1, 3, false, []string{"alice", "X", "Y", "Z", "bob", "W", "charlie", "darren", "erin"}, nil},
+		{"invalidRatio", 0, 1, false, nil, fmt.Errorf("InterleaveRatio: ratios must be at least 1, got 0 and 1")},
+		{"masterContextCanceled", 2, 1, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			primary := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+			secondary := SliceSource(context.Background(), []string{"X", "Y", "Z", "W"})
+
+			var actualNames []string
+			err := Sink(InterleaveRatio(primary, secondary, test.pRatio, test.sRatio), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestIntersperse(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie"}, false, []string{"alice", ",", "bob", ",", "charlie"}, nil},
+		{"single", []string{"alice"}, false, []string{"alice"}, nil},
+		{"empty", nil, false, nil, nil},
+		{"masterContextCanceled", []string{"alice", "bob"}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, test.names)
+
+			var actualNames []string
+			err := Sink(Intersperse(names, ","), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestIterate(t *testing.T) {
+	tests := []struct {
+		name            string
+		n               int
+		fnError         error
+		cancelContext   bool
+		expectedNumbers []int
+		expectedError   error
+	}{
+		{"nominal", 3, nil, false, []int{8, 16, 24}, nil},
+		{"zero", 0, nil, false, []int{1, 2, 3}, nil},
+		{"negative", -1, nil, false, nil, fmt.Errorf("Iterate: n must not be negative, got -1")},
+		{"fnError", 3, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", 3, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			numbers := SliceSource(ctx, []int{1, 2, 3})
+
+			doubled := Iterate(numbers, test.n, func(_ context.Context, n int) (int, error) {
+				return n * 2, test.fnError
+			})
+
+			var actualNumbers []int
+			err := Sink(doubled, func(_ context.Context, n int) error {
+				actualNumbers = append(actualNumbers, n)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNumbers, actualNumbers, "wrong numbers")
+			}
+		})
+	}
+}
+
+func TestIteratorSource(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name           string
+		nextError      error
+		closeError     error
+		cancelContext  bool
+		expectedErrors []error
+	}{
+		{"nominal", nil, nil, false, nil},
+		{"nextError", assert.AnError, nil, false, []error{assert.AnError}},
+		{"closeError", nil, io.ErrClosedPipe, false, []error{io.ErrClosedPipe}},
+		{"bothErrors", assert.AnError, io.ErrClosedPipe, false, []error{assert.AnError, io.ErrClosedPipe}},
+		{"masterContextCanceled", nil, nil, true, []error{context.Canceled}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			it := &testIterator{values: expectedNames, nextError: test.nextError, closeError: test.closeError}
+
+			actualNames := make([]string, 0)
+			err := Sink(IteratorSource[string](ctx, it), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.True(t, it.closed, "iterator not closed")
+
+			if test.expectedErrors == nil {
+				assert.NoError(t, err, "wrong error")
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+
+			for _, expectedError := range test.expectedErrors {
+				assert.ErrorIs(t, err, expectedError, "wrong error")
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	type order struct {
+		customer string
+		item     string
+	}
+
+	expectedPairs := []Pair[string, order]{
+		{"alice", order{"alice", "apples"}},
+		{"alice", order{"alice", "avocados"}},
+		{"charlie", order{"charlie", "cherries"}},
+	}
+
+	tests := []struct {
+		name          string
+		sourceError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"sourceError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			customers := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+			orders := Source(ctx, func(_ context.Context, emit func(order) error) error {
+				for _, o := range []order{{"alice", "apples"}, {"charlie", "cherries"}, {"alice", "avocados"}, {"erin", "eggs"}} {
+					if err := emit(o); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+
+			joined := Join(customers, orders, func(name string) string {
+				return name
+			}, func(o order) string {
+				return o.customer
+			})
+
+			actualPairs := make([]Pair[string, order], 0)
+			err := Sink(joined, func(_ context.Context, pair Pair[string, order]) error {
+				actualPairs = append(actualPairs, pair)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedPairs, actualPairs, "wrong pairs")
+			}
+		})
+	}
+}
+
+func TestLatestByKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		keyError      error
+		cancelContext bool
+		expectedMap   map[byte]string
+		expectedError error
+	}{
+		{"nominal", nil, false, map[byte]string{'a': "anna", 'b': "bob", 'c': "charlie"}, nil},
+		{"keyError", assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "anna", "charlie"})
+
+			actualMap, err := LatestByKey(names, func(_ context.Context, name string) (byte, error) {
+				return name[0], test.keyError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedMap, actualMap, "wrong map")
+			}
+		})
+	}
+}
+
+func TestMapFirst(t *testing.T) {
+	tests := []struct {
+		name          string
+		rows          []string
+		mapperError   error
+		cancelContext bool
+		expectedRows  []string
+		expectedError error
+	}{
+		{"nominal", []string{"name", "alice", "bob"}, nil, false, []string{"NAME", "alice", "bob"}, nil},
+		{"single", []string{"name"}, nil, false, []string{"NAME"}, nil},
+		{"empty", nil, nil, false, nil, nil},
+		{"mapperError", []string{"name", "alice"}, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", []string{"name", "alice"}, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			rows := SliceSource(ctx, test.rows)
+			mapped := MapFirst(rows, func(_ context.Context, row string) (string, error) {
+				return strings.ToUpper(row), test.mapperError
+			})
+
+			var actualRows []string
+			err := Sink(mapped, func(_ context.Context, row string) error {
+				actualRows = append(actualRows, row)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedRows, actualRows, "wrong rows")
+			}
+		})
+	}
+}
+
+func TestMapLast(t *testing.T) {
+	tests := []struct {
+		name          string
+		rows          []string
+		sourceError   error
+		mapperError   error
+		cancelContext bool
+		expectedRows  []string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob", "total"}, nil, nil, false, []string{"alice", "bob", "TOTAL"}, nil},
+		{"single", []string{"total"}, nil, nil, false, []string{"TOTAL"}, nil},
+		{"empty", nil, nil, nil, false, nil, nil},
+		{"sourceError", []string{"alice", "bob"}, assert.AnError, nil, false, nil, assert.AnError},
+		{"mapperError", []string{"alice", "total"}, nil, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", []string{"alice", "total"}, nil, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			rows := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, row := range test.rows {
+					if err := emit(row); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+			mapped := MapLast(rows, func(_ context.Context, row string) (string, error) {
+				return strings.ToUpper(row), test.mapperError
+			})
+
+			var actualRows []string
+			err := Sink(mapped, func(_ context.Context, row string) error {
+				actualRows = append(actualRows, row)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedRows, actualRows, "wrong rows")
+			}
+		})
+	}
+}
+
+func TestMapOrElse(t *testing.T) {
+	tests := []struct {
+		name            string
+		fallbackError   error
+		cancelContext   bool
+		expectedLengths []int
+		expectedErrors  []error
+		expectedError   error
+	}{
+		{"nominal", nil, false, []int{5, -1, 7}, []error{assert.AnError}, nil},
+		{"fallbackError", errors.New("fallback failed"), false, nil, []error{assert.AnError}, errors.New("fallback failed")},
+		{"masterContextCanceled", nil, true, nil, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+
+			var actualErrors []error
+			lengths := MapOrElse(names, func(_ context.Context, name string) (int, error) {
+				if name == "bob" {
+					return 0, assert.AnError
+				}
+				return len(name), nil
+			}, func(_ context.Context, _ string, err error) (int, error) {
+				actualErrors = append(actualErrors, err)
+				return -1, test.fallbackError
+			})
+
+			var actualLengths []int
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
+				assert.Equal(t, test.expectedErrors, actualErrors, "wrong fallback errors")
+			}
+		})
+	}
+}
+
+func TestMapPhased(t *testing.T) {
+	tests := []struct {
+		name          string
+		ioWorkers     int
+		cpuWorkers    int
+		ioError       error
+		cpuError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 4, 2, nil, nil, false, nil},
+		{"defaultCPUWorkers", 4, 0, nil, nil, false, nil},
+		{"ioError", 4, 2, assert.AnError, nil, false, assert.AnError},
+		{"cpuError", 4, 2, nil, assert.AnError, false, assert.AnError},
+		{"invalidWorkers", 0, 2, nil, nil, false, fmt.Errorf("MapPhased: workers must be at least 1, got 0 and 2")},
+		{"masterContextCanceled", 4, 2, nil, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+
+			lengths := MapPhased(names, test.ioWorkers, func(_ context.Context, name string) (string, error) {
+				return strings.ToUpper(name), test.ioError
+			}, test.cpuWorkers, func(_ context.Context, name string) (string, error) {
+				return name + "!", test.cpuError
+			})
+
+			actualNames := make(map[string]bool)
+			err := Sink(lengths, func(_ context.Context, name string) error {
+				actualNames[name] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, map[string]bool{"ALICE!": true, "BOB!": true, "CHARLIE!": true}, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestMapSafe(t *testing.T) {
+	expectedLengths := []int{5, 3, 7, 5, 4}
+
+	tests := []struct {
+		name          string
+		panicOn       string
+		mapError      error
+		expectPanic   bool
+		expectedError error
+	}{
+		{"nominal", "", nil, false, nil},
+		{"mapError", "", assert.AnError, false, assert.AnError},
+		{"mapPanic", "bob", nil, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
+
+			lengths := MapSafe(names, func(_ context.Context, name string) (int, error) {
+				if name == test.panicOn {
+					panic("boom")
+				}
+
+				return len(name), test.mapError
+			})
+
+			actualLengths := make([]int, 0)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			if test.expectPanic {
+				var panicErr *PanicError
+				if assert.ErrorAs(t, err, &panicErr, "wrong error") {
+					assert.Equal(t, "boom", panicErr.Value, "wrong panic value")
+					assert.NotEmpty(t, panicErr.Stack, "missing stack")
+				}
+				return
+			}
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+			}
+		})
+	}
+}
+
+func TestMapSafeSkip(t *testing.T) {
+	tests := []struct {
+		name            string
+		panicOn         string
+		mapError        error
+		expectedError   error
+		expectedLengths []int
+		expectedSkipped []string
+	}{
+		{"nominal", "", nil, nil, []int{5, 3, 7, 5, 4}, []string{}},
+		{"mapError", "", assert.AnError, assert.AnError, nil, nil},
+		{"mapPanic", "bob", nil, nil, []int{5, 7, 5, 4}, []string{"bob"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
+
+			actualSkipped := make([]string, 0)
+			lengths := MapSafeSkip(names, func(_ context.Context, name string) (int, error) {
+				if name == test.panicOn {
+					panic("boom")
+				}
+
+				return len(name), test.mapError
+			}, func(_ context.Context, name string, err *PanicError) {
+				actualSkipped = append(actualSkipped, name)
+			})
+
+			actualLengths := make([]int, 0)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
+				assert.Equal(t, test.expectedSkipped, actualSkipped, "wrong skipped names")
+			}
+		})
+	}
+}
+
+func TestMapSlice(t *testing.T) {
+	expectedLengths := [][]int{{5, 3}, {}, {7, 5, 4}}
+
+	tests := []struct {
+		name          string
+		mapError      error
+		expectedError error
+	}{
+		{"nominal", nil, nil},
+		{"mapError", assert.AnError, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batches := SliceSource(context.Background(), [][]string{{"alice", "bob"}, {}, {"charlie", "david", "erin"}})
+
+			lengths := MapSlice(batches, func(_ context.Context, names []string) ([]int, error) {
+				output := make([]int, len(names))
+
+				for i, name := range names {
+					output[i] = len(name)
+				}
+
+				return output, test.mapError
+			})
+
+			actualLengths := make([][]int, 0)
+			err := Sink(lengths, func(_ context.Context, batch []int) error {
+				actualLengths = append(actualLengths, batch)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+			}
+		})
+	}
+}
+
+func TestMapTimed(t *testing.T) {
+	tests := []struct {
+		name          string
+		mapError      error
+		cancelContext bool
+		expectedCount int
+		expectedError error
+	}{
+		{"nominal", nil, false, 3, nil},
+		{"mapError", assert.AnError, false, 1, assert.AnError},
+		{"masterContextCanceled", nil, true, 0, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"bob", "alice", "charlie"})
+
+			lengths, timings := MapTimed(names, func(_ context.Context, name string) (int, error) {
+				time.Sleep(time.Duration(len(name)) * time.Millisecond)
+				return len(name), test.mapError
+			})
+
+			var actualLengths []int
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if !test.cancelContext {
+				assert.Equal(t, test.expectedCount, timings.Count(), "wrong count")
+			}
+
+			if test.expectedError == nil {
+				assert.Equal(t, []int{3, 5, 7}, actualLengths, "wrong lengths")
+				assert.GreaterOrEqual(t, timings.Min(), 3*time.Millisecond, "wrong min")
+				assert.GreaterOrEqual(t, timings.Max(), 7*time.Millisecond, "wrong max")
+				assert.GreaterOrEqual(t, timings.Total(), 15*time.Millisecond, "wrong total")
+				assert.Equal(t, timings.Total()/3, timings.Mean(), "wrong mean")
+				assert.Equal(t, timings.Min(), timings.Percentile(0), "wrong 0th percentile")
+				assert.Equal(t, timings.Max(), timings.Percentile(1), "wrong 100th percentile")
+				assert.Less(t, timings.Percentile(0.5), timings.Max(), "wrong median")
+				assert.Equal(t, timings.Max(), timings.Percentile(99), "out-of-range p not clamped")
+				assert.Equal(t, timings.Min(), timings.Percentile(-1), "out-of-range p not clamped")
+				assert.Zero(t, timings.Percentile(math.NaN()), "NaN p not zero")
+			}
+		})
+	}
+}
+
+func TestMapWhere(t *testing.T) {
+	tests := []struct {
+		name          string
+		predError     error
+		mapError      error
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", nil, nil, []string{"ALICE", "bob", "CHARLIE"}, nil},
+		{"predError", assert.AnError, nil, nil, assert.AnError},
+		{"mapError", nil, assert.AnError, nil, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie"})
+
+			shouted := MapWhere(names, func(_ context.Context, name string) (bool, error) {
+				return len(name) > 3, test.predError
+			}, func(_ context.Context, name string) (string, error) {
+				return strings.ToUpper(name), test.mapError
+			})
+
+			var actualNames []string
+			err := Sink(shouted, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestMapWithResource(t *testing.T) {
+	tests := []struct {
+		name          string
+		workers       int
+		createError   error
+		mapError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 2, nil, nil, false, nil},
+		{"createError", 2, assert.AnError, nil, false, assert.AnError},
+		{"mapError", 2, nil, assert.AnError, false, assert.AnError},
+		{"invalidWorkers", 0, nil, nil, false, fmt.Errorf("MapWithResource: workers must be at least 1, got 0")},
+		{"masterContextCanceled", 2, nil, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+
+			var created, released atomic.Int32
+			lengths := MapWithResource(names, func(context.Context) (*strings.Builder, error) {
+				if test.createError != nil {
+					return nil, test.createError
+				}
+				created.Add(1)
+				return &strings.Builder{}, nil
+			}, func(_ context.Context, b *strings.Builder, name string) (int, error) {
+				b.Reset()
+				b.WriteString(name)
+				return b.Len(), test.mapError
+			}, func(*strings.Builder) {
+				released.Add(1)
+			}, test.workers)
+
+			actualLengths := make(map[int]int)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths[length]++
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.LessOrEqual(t, created.Load(), int32(2), "too many resources created")
+			assert.Equal(t, created.Load(), released.Load(), "wrong number of resources released")
+
+			if test.expectedError == nil {
+				assert.Equal(t, map[int]int{5: 1, 3: 1, 7: 1, 6: 1, 4: 1}, actualLengths, "wrong lengths")
+			}
+		})
+	}
+}
+
+func TestMapWithRetryer(t *testing.T) {
+	expectedLengths := []int{5, 3, 7}
+
+	tests := []struct {
+		name          string
+		failures      int
+		maxAttempts   int
+		expectedError error
+	}{
+		{"nominal", 0, 3, nil},
+		{"recovered", 2, 3, nil},
+		{"exhausted", 3, 3, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie"})
+
+			retryer := RetryerFunc(func(attempt int, _ error) (time.Duration, bool) {
+				return time.Millisecond, attempt < test.maxAttempts
+			})
+
+			failures := make(map[string]int)
+			lengths := MapWithRetryer(names, retryer, func(_ context.Context, name string) (int, error) {
+				if failures[name] < test.failures {
+					failures[name]++
+					return 0, assert.AnError
+				}
+
+				return len(name), nil
+			})
+
+			actualLengths := make([]int, 0)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths = append(actualLengths, length)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+			}
+		})
+	}
+}
+
+func TestMergeFair(t *testing.T) {
+	expectedNames := map[string]any{
+		"alice":   true,
+		"bob":     true,
+		"charlie": true,
+		"darren":  true,
+		"erin":    true,
+		"frank":   true,
+	}
+
+	tests := []struct {
+		name          string
+		sourceError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"sourceError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			first := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+			second := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"darren", "erin", "frank"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+
+			actualNames := make(map[string]any)
+			err := Sink(MergeFair(first, second), func(_ context.Context, name string) error {
+				actualNames[name] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+
+	t.Run("fairness", func(t *testing.T) {
+		// The fast input always has a value waiting, so only round-robin lets the slow one keep up.
+		fast := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+			for {
+				if err := emit("fast"); err != nil {
+					return err
+				}
+			}
+		})
+		slow := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+			for i := 0; i < 10; i++ {
+				if err := emit("slow"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		paced := Map(MergeFair(fast, slow), func(_ context.Context, name string) (string, error) {
+			time.Sleep(time.Millisecond)
+			return name, nil
+		})
+
+		actualNames, err := TakeSlice(paced, 30)
+
+		assert.NoError(t, err, "wrong error")
+
+		var since, slows int
+		for _, name := range actualNames {
+			if name == "slow" {
+				if slows > 0 {
+					assert.LessOrEqual(t, since, 1, "slow input passed over")
+				}
+				since, slows = 0, slows+1
+			} else {
+				since++
+			}
+		}
+		assert.Equal(t, 10, slows, "wrong number of slow values")
+	})
+}
+
+func TestMergeSorted(t *testing.T) {
+	tests := []struct {
+		name           string
+		sourceError    error
+		cancelContext  bool
+		expectedValues []int
+		expectedError  error
+	}{
+		{"nominal", nil, false, []int{1, 2, 3, 4, 4, 5, 6, 7, 8, 9}, nil},
+		{"sourceError", assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			first := SliceSource(ctx, []int{1, 4, 7, 8})
+			second := Source(ctx, func(_ context.Context, emit func(int) error) error {
+				for _, value := range []int{2, 4, 5, 9} {
+					if err := emit(value); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+			third := SliceSource(ctx, []int{3, 6})
+
+			merged := MergeSorted(func(a, b int) bool {
+				return a < b
+			}, first, second, third)
+
+			var actualValues []int
+			err := Sink(merged, func(_ context.Context, value int) error {
+				actualValues = append(actualValues, value)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedValues, actualValues, "wrong values")
+			}
+		})
+	}
+}
+
+func TestMergeTagged(t *testing.T) {
+	expectedNames := map[Tagged[string]]any{
+		{0, "alice"}:   true,
+		{0, "bob"}:     true,
+		{1, "charlie"}: true,
+		{1, "darren"}:  true,
+		{2, "erin"}:    true,
+	}
+
+	tests := []struct {
+		name          string
+		sourceError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"sourceError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			first := SliceSource(ctx, []string{"alice", "bob"})
+			second := SliceSource(ctx, []string{"charlie", "darren"})
+			third := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				if err := emit("erin"); err != nil {
+					return err
+				}
+
+				return test.sourceError
+			})
+
+			actualNames := make(map[Tagged[string]]any)
+			err := Sink(MergeTagged(first, second, third), func(_ context.Context, name Tagged[string]) error {
+				actualNames[name] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           MonotonicMode
+		cancelContext  bool
+		expectedEvents []int64
+		expectedError  error
+	}{
+		{"drop", DropMode, false, []int64{1, 3, 3, 5, 6}, nil},
+		{"error", ErrorMode, false, nil, fmt.Errorf("%w: key 2 after 3", ErrOutOfOrder)},
+		{"masterContextCanceled", DropMode, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			events := SliceSource(ctx, []int64{1, 3, 2, 3, 5, 4, 6})
+			ordered := Monotonic(events, func(event int64) int64 {
+				return event
+			}, test.mode)
+
+			var actualEvents []int64
+			err := Sink(ordered, func(_ context.Context, event int64) error {
+				actualEvents = append(actualEvents, event)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedEvents, actualEvents, "wrong events")
+			}
+		})
+	}
+}
+
+func TestMovingMedian(t *testing.T) {
+	tests := []struct {
+		name            string
+		readings        []float64
+		window          int
+		cancelContext   bool
+		expectedMedians []float64
+		expectedError   error
+	}{
+		{"nominal", []float64{5, 1, 3, 100, 4, 6}, 3, false, []float64{5, 3, 3, 3, 4, 6}, nil},
+		{"evenWindow", []float64{1, 3, 2, 10}, 2, false, []float64{1, 2, 2.5, 6}, nil},
+		{"windowOfOne", []float64{4, 2}, 1, false, []float64{4, 2}, nil},
+		{"nan", []float64{1, math.NaN(), 2}, 2, false, nil, ErrNaN},
+		{"invalidWindow", []float64{1}, 0, false, nil, fmt.Errorf("MovingMedian: window must be at least 1, got 0")},
+		{"masterContextCanceled", []float64{1, 2}, 3, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			readings := SliceSource(ctx, test.readings)
+
+			var actualMedians []float64
+			err := Sink(MovingMedian(readings, test.window), func(_ context.Context, median float64) error {
+				actualMedians = append(actualMedians, median)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedMedians, actualMedians, "wrong medians")
+			}
+		})
+	}
+
+	t.Run("matchesSorting", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		readings := make([]float64, 500)
+		for i := range readings {
+			readings[i] = float64(rng.Intn(50))
+		}
+
+		// Each median is checked against one found by sorting the window from scratch.
+		const window = 7
+		var expectedMedians []float64
+		for i := range readings {
+			sorted := append([]float64(nil), readings[max(0, i+1-window):i+1]...)
+			sort.Float64s(sorted)
+
+			median := sorted[len(sorted)/2]
+			if len(sorted)%2 == 0 {
+				median = (median + sorted[len(sorted)/2-1]) / 2
+			}
+			expectedMedians = append(expectedMedians, median)
+		}
+
+		var actualMedians []float64
+		err := Sink(MovingMedian(SliceSource(context.Background(), readings), window), func(_ context.Context, median float64) error {
+			actualMedians = append(actualMedians, median)
+			return nil
+		})
+
+		assert.NoError(t, err, "wrong error")
+		assert.Equal(t, expectedMedians, actualMedians, "wrong medians")
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name           string
+		values         []float64
+		cancelContext  bool
+		expectedValues []float64
+		expectedError  error
+	}{
+		{"nominal", []float64{10, 20, 15, 30}, false, []float64{0, 0.5, 0.25, 1}, nil},
+		{"constant", []float64{7, 7, 7}, false, []float64{0, 0, 0}, nil},
+		{"empty", nil, false, nil, nil},
+		{"masterContextCanceled", []float64{1, 2}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			values := SliceSource(ctx, test.values)
+
+			var actualValues []float64
+			err := Sink(Normalize(values), func(_ context.Context, value float64) error {
+				actualValues = append(actualValues, value)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedValues, actualValues, "wrong values")
+			}
+		})
+	}
+}
+
+func TestNormalizeWindow(t *testing.T) {
+	tests := []struct {
+		name           string
+		values         []float64
+		window         int
+		cancelContext  bool
+		expectedValues []float64
+		expectedError  error
+	}{
+		{"nominal", []float64{10, 20, 15, 30, 22.5}, 3, false, []float64{0, 1, 0.5, 1, 0.5}, nil},
+		{"windowOfOne", []float64{1, 2, 3}, 1, false, []float64{0, 0, 0}, nil},
+		{"constant", []float64{7, 7, 7}, 2, false, []float64{0, 0, 0}, nil},
+		{"invalidWindow", []float64{1}, 0, false, nil, fmt.Errorf("NormalizeWindow: window must be at least 1, got 0")},
+		{"masterContextCanceled", []float64{1, 2}, 2, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			values := SliceSource(ctx, test.values)
+
+			var actualValues []float64
+			err := Sink(NormalizeWindow(values, test.window), func(_ context.Context, value float64) error {
+				actualValues = append(actualValues, value)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedValues, actualValues, "wrong values")
+			}
+		})
+	}
+}
+
+func TestOnToggle(t *testing.T) {
+	type toggle struct {
+		state bool
+		value int
+	}
+
+	tests := []struct {
+		name            string
+		readings        []int
+		onChangeError   error
+		cancelContext   bool
+		expectedToggles []toggle
+		expectedError   error
+	}{
+		{"nominal", []int{20, 60, 70, 40, 30, 80}, nil, false, []toggle{{true, 60}, {false, 40}, {true, 80}}, nil},
+		{"startAbove", []int{60, 70}, nil, false, nil, nil},
+		{"onChangeError", []int{20, 60, 70}, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", []int{20, 60}, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			readings := SliceSource(ctx, test.readings)
+
+			var actualToggles []toggle
+			toggled := OnToggle(readings, func(reading int) bool {
+				return reading > 50
+			}, func(_ context.Context, state bool, reading int) error {
+				actualToggles = append(actualToggles, toggle{state, reading})
+				return test.onChangeError
+			})
+
+			var actualReadings []int
+			err := Sink(toggled, func(_ context.Context, reading int) error {
+				actualReadings = append(actualReadings, reading)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.readings, actualReadings, "wrong readings")
+				assert.Equal(t, test.expectedToggles, actualToggles, "wrong toggles")
+			}
+		})
+	}
+}
+
+func TestPace(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		gap           time.Duration
+		timeout       time.Duration
+		expectedError error
+	}{
+		{"nominal", time.Millisecond, time.Minute, nil},
+		{"deadlineExceeded", time.Minute, 10 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+
+			names := SliceSource(ctx, expectedNames)
+
+			pacedNames := Pace(names, func(name string) time.Duration {
+				return time.Duration(len(name)) * test.gap
+			})
+
+			start := time.Now()
+			actualNames := make([]string, 0)
+			err := Sink(pacedNames, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.GreaterOrEqual(t, time.Since(start), 15*test.gap, "values not paced")
+			}
+		})
+	}
+}
+
+func TestPageSource(t *testing.T) {
+	pages := [][]string{{"alice", "bob"}, {}, {"charlie", "david"}, {"erin"}}
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		fetchError    error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"fetchError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := PageSource(ctx, 0, func(_ context.Context, page int) ([]string, int, bool, error) {
+				return pages[page], page + 1, page == len(pages)-1, test.fetchError
+			})
+
+			actualNames := make([]string, 0)
+			err := Sink(names, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		name          string
+		sinkError     error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"sinkError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := make(chan string)
+			var actualNames []string
+
+			err := Parallel(ctx, func(ctx context.Context) error {
+				defer close(names)
+
+				return Sink(SliceSource(ctx, []string{"alice", "bob"}), func(ctx context.Context, name string) error {
+					select {
+					case names <- name:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+			}, func(ctx context.Context) error {
+				received := Source(ctx, func(ctx context.Context, emit func(string) error) error {
+					for name := range names {
+						if err := emit(name); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+
+				return Sink(received, func(_ context.Context, name string) error {
+					actualNames = append(actualNames, name)
+					return test.sinkError
+				})
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, []string{"alice", "bob"}, actualNames, "wrong names")
+			}
+		})
+	}
+
+	t.Run("failFast", func(t *testing.T) {
+		// The second function never finishes on its own, so Parallel returns only if the failure of the first
+		// cancels it.
+		err := Parallel(context.Background(), func(context.Context) error {
+			return assert.AnError
+		}, func(ctx context.Context) error {
+			endless := Source(ctx, func(ctx context.Context, emit func(int) error) error {
+				for {
+					if err := emit(0); err != nil {
+						return err
+					}
+				}
+			})
+
+			return Sink(endless, func(context.Context, int) error {
+				return nil
+			})
+		})
+
+		assert.Equal(t, assert.AnError, err, "wrong error")
+	})
+}
+
+func TestParallelBatchMap(t *testing.T) {
+	expectedLengths := map[int]any{
+		3: true,
+		4: true,
+		5: true,
+		6: true,
+		7: true,
+	}
+
+	tests := []struct {
+		name          string
+		mapError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"mapError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+
+			var mu sync.Mutex
+			batchSizes := make([]int, 0)
+
+			mappedLengths := ParallelBatchMap(names, 2, 2, func(_ context.Context, batch []string) ([]int, error) {
+				mu.Lock()
+				batchSizes = append(batchSizes, len(batch))
+				mu.Unlock()
+
+				lengths := make([]int, len(batch))
+				for i, name := range batch {
+					lengths[i] = len(name)
+				}
+
+				return lengths, test.mapError
+			})
+
+			actualLengths := make(map[int]any)
+			err := Sink(mappedLengths, func(_ context.Context, length int) error {
+				actualLengths[length] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+				assert.ElementsMatch(t, []int{2, 2, 1}, batchSizes, "wrong batch sizes")
+			}
+		})
+	}
+}
+
+func TestParallelFilter(t *testing.T) {
+	expectedNames := map[string]any{
+		"alice":   true,
+		"charlie": true,
+		"darren":  true,
+	}
+
+	tests := []struct {
+		name          string
+		filterError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"filterError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+
+			filteredNames := ParallelFilter(names, func(_ context.Context, name string) (bool, error) {
+				return strings.ContainsRune(name, 'a'), test.filterError
+			})
+
+			actualNames := make(map[string]any)
+			err := Sink(filteredNames, func(_ context.Context, name string) error {
+				actualNames[name] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestParallelJoin(t *testing.T) {
+	tests := []struct {
+		name          string
+		errs          []error
+		expectedError error
+	}{
+		{"nominal", []error{nil, nil}, nil},
+		{"oneError", []error{nil, assert.AnError}, errors.Join(assert.AnError)},
+		{"allErrors", []error{assert.AnError, io.EOF}, errors.Join(assert.AnError, io.EOF)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fns := make([]func() error, len(test.errs))
+			for i, err := range test.errs {
+				err := err
+				fns[i] = func() error {
+					return Sink(SliceSource(context.Background(), []string{"alice"}), func(context.Context, string) error {
+						return err
+					})
+				}
+			}
+
+			err := ParallelJoin(fns...)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+		})
+	}
+}
+
+func TestParallelMap(t *testing.T) {
+	expectedLengths := map[int]any{
+		3: true,
+		4: true,
+		5: true,
+		6: true,
+		7: true,
+	}
+
+	tests := []struct {
+		name          string
+		mapError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"mapError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "darren", "erin"})
+
+			mappedLengths := ParallelMap(names, func(_ context.Context, name string) (int, error) {
+				return len(name), test.mapError
+			})
+
+			actualLengths := make(map[int]any)
+			err := Sink(mappedLengths, func(_ context.Context, length int) error {
+				actualLengths[length] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedLengths, actualLengths, "wrong lengths")
+			}
+		})
+	}
+}
+
+func TestParallelMapKeyed(t *testing.T) {
+	expectedNames := map[byte][]string{
+		'a': {"alice", "anna", "arthur"},
+		'b': {"bob", "beth"},
+		'c': {"charlie"},
+	}
+
+	tests := []struct {
+		name          string
+		workers       int
+		mapError      error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 2, nil, false, nil},
+		{"mapError", 2, assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", 2, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "anna", "charlie", "beth", "arthur"})
+
+			keyedNames := ParallelMapKeyed(names, test.workers, func(name string) byte {
+				return name[0]
+			}, func(_ context.Context, name string) (string, error) {
+				return name, test.mapError
+			})
+
+			actualNames := make(map[byte][]string)
+			err := Sink(keyedNames, func(_ context.Context, name string) error {
+				actualNames[name[0]] = append(actualNames[name[0]], name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+
+	t.Run("invalidWorkers", func(t *testing.T) {
+		names := SliceSource(context.Background(), []string{"alice", "bob"})
+
+		keyedNames := ParallelMapKeyed(names, 0, func(name string) byte {
+			return name[0]
+		}, func(_ context.Context, name string) (string, error) {
+			return name, nil
+		})
+
+		err := Sink(keyedNames, func(_ context.Context, _ string) error {
+			return nil
+		})
+
+		assert.Error(t, err, "missing error")
+	})
+}
+
+func TestParallelMapRateLimited(t *testing.T) {
+	tests := []struct {
+		name            string
+		workers         int
+		mapperError     error
+		cancelContext   bool
+		expectedLengths map[int]any
+		expectedError   error
+	}{
+		{"nominal", 3, nil, false, map[int]any{5: true, 3: true, 7: true, 4: true}, nil},
+		{"mapperError", 3, assert.AnError, false, nil, assert.AnError},
+		{"invalidWorkers", 0, nil, false, nil, fmt.Errorf("ParallelMapRateLimited: workers must be at least 1, got 0")},
+		{"masterContextCanceled", 3, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "dave"})
+			limiter := rate.NewLimiter(rate.Every(20*time.Millisecond), 1)
+
+			lengths := ParallelMapRateLimited(names, test.workers, limiter, func(_ context.Context, name string) (int, error) {
+				return len(name), test.mapperError
+			})
+
+			start := time.Now()
+			actualLengths := make(map[int]any)
+			err := Sink(lengths, func(_ context.Context, length int) error {
+				actualLengths[length] = true
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
+				assert.GreaterOrEqual(t, time.Since(start), 55*time.Millisecond, "rate not limited")
+			}
+		})
+	}
+}
+
+func TestParallelMapResults(t *testing.T) {
+	tests := []struct {
+		name            string
+		workers         int
+		cancelContext   bool
+		expectedResults map[string]error
+		expectedError   error
+	}{
+		{"nominal", 2, false, map[string]error{"ALICE": nil, "": assert.AnError, "CHARLIE": nil}, nil},
+		{"invalidWorkers", 0, false, nil, fmt.Errorf("ParallelMapResults: workers must be at least 1, got 0")},
+		{"masterContextCanceled", 2, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+
+			results := ParallelMapResults(names, test.workers, func(_ context.Context, name string) (string, error) {
+				if name == "bob" {
+					return "", assert.AnError
+				}
+				return strings.ToUpper(name), nil
+			})
+
+			actualResults := make(map[string]error)
+			err := Sink(results, func(_ context.Context, result Result[string]) error {
+				actualResults[result.Value] = result.Err
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedResults, actualResults, "wrong results")
+			}
+		})
+	}
+}
+
+func TestPartitionedFileSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		partitions    int
+		writeError    error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 9, 3, nil, false, nil},
+		{"manyPartitions", 300, 100, nil, false, nil},
+		{"writeError", 9, 3, assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", 9, 3, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			values := make([]int, test.count)
+			expectedFiles := make(map[string]string)
+			for i := range values {
+				values[i] = i
+				expectedFiles[fmt.Sprintf("%d.data", i%test.partitions)] += fmt.Sprintf("%d\n", i)
+			}
+			if test.writeError != nil {
+				expectedFiles = map[string]string{"0.data": "0\n"}
+			}
+
+			dir := filepath.Join(t.TempDir(), "partitions")
+			err := PartitionedFileSink(SliceSource(ctx, values), dir, func(value int) string {
+				return fmt.Sprint(value % test.partitions)
+			}, func(w io.Writer, value int) error {
+				if _, err := fmt.Fprintf(w, "%d\n", value); err != nil {
+					return err
+				}
+				return test.writeError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if !test.cancelContext {
+				entries, err := os.ReadDir(dir)
+				assert.NoError(t, err, "wrong error")
+
+				actualFiles := make(map[string]string)
+				for _, entry := range entries {
+					content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+					assert.NoError(t, err, "wrong error")
+					actualFiles[entry.Name()] = string(content)
+				}
+
+				assert.Equal(t, expectedFiles, actualFiles, "wrong files")
+			}
+		})
+	}
+}
+
+func TestPercentileWindow(t *testing.T) {
+	tests := []struct {
+		name                string
+		latencies           []float64
+		window              int
+		p                   float64
+		cancelContext       bool
+		expectedPercentiles []float64
+		expectedError       error
+	}{
+		{"median", []float64{5, 1, 3, 9, 7, 2}, 3, 0.5, false, []float64{5, 5, 3, 3, 7, 7}, nil},
+		{"maximum", []float64{5, 1, 3, 9, 7, 2}, 3, 1, false, []float64{5, 5, 5, 9, 9, 9}, nil},
+		{"minimum", []float64{5, 1, 3, 9, 7, 2}, 3, 0, false, []float64{5, 1, 1, 1, 3, 2}, nil},
+		{"duplicates", []float64{4, 4, 4, 1}, 2, 1, false, []float64{4, 4, 4, 4}, nil},
+		{"nan", []float64{1, math.NaN(), 2, 3, 4}, 2, 0.5, false, nil, ErrNaN},
+		{"invalidWindow", []float64{1}, 0, 0.5, false, nil, fmt.Errorf("PercentileWindow: window must be at least 1, got 0")},
+		{"invalidP", []float64{1}, 3, 99, false, nil, fmt.Errorf("PercentileWindow: p must be between 0 and 1, got 99")},
+		{"masterContextCanceled", []float64{1, 2}, 3, 0.5, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			latencies := SliceSource(ctx, test.latencies)
+
+			var actualPercentiles []float64
+			err := Sink(PercentileWindow(latencies, test.window, test.p), func(_ context.Context, percentile float64) error {
+				actualPercentiles = append(actualPercentiles, percentile)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedPercentiles, actualPercentiles, "wrong percentiles")
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	expectedNames := []string{
+		"alice",
+		"alice",
+		"alice",
+		"alice",
+		"alice",
+		"charlie",
+		"charlie",
+		"charlie",
+		"charlie",
+		"charlie",
+		"charlie",
+		"charlie",
+		"david",
+		"david",
+		"david",
+		"david",
+		"david",
+	}
+
+	tests := []struct {
+		name          string
+		sourceError   error
+		filterError   error
+		mapError      error
+		sinkError     error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, nil, nil, nil, false, nil},
+		{"sourceError", assert.AnError, nil, nil, nil, false, assert.AnError},
+		{"filterError", nil, assert.AnError, nil, nil, false, assert.AnError},
+		{"mapError", nil, nil, assert.AnError, nil, false, assert.AnError},
+		{"sinkError", nil, nil, nil, assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, nil, nil, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "bob", "charlie", "david", "erin"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+
+			namesWithA := Filter(names, func(_ context.Context, name string) (bool, error) {
+				return strings.ContainsRune(name, 'a'), test.filterError
+			})
+
+			repeatedNames := Map(namesWithA, func(_ context.Context, name string) ([]string, error) {
+				output := make([]string, len(name))
+
+				for i := range output {
+					output[i] = name
+				}
+
+				return output, test.mapError
+			})
+
+			flattenedNames := Flatten(repeatedNames)
+
+			actualNames := make([]string, 0)
+			err := Sink(flattenedNames, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return test.sinkError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestPipelineNamed(t *testing.T) {
+	tests := []struct {
+		name            string
+		take            int
+		mapper          func(context.Context, string) (int, error)
+		expectedLengths []int
+		expectedPanic   bool
+		expectedError   error
+	}{
+		{"nominal", 3, func(_ context.Context, name string) (int, error) {
+			return len(name), nil
+		}, []int{5, 3}, false, nil},
+		{"stoppedEarly", 1, func(_ context.Context, name string) (int, error) {
+			return len(name), nil
+		}, []int{5}, false, nil},
+		{"mapError", 3, func(_ context.Context, name string) (int, error) {
+			return 0, assert.AnError
+		}, nil, false, assert.AnError},
+		{"mapPanic", 3, func(_ context.Context, name string) (int, error) {
+			panic("boom")
+		}, nil, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob"}).Named("names")
+			lengths := MapSafe(names, test.mapper)
+
+			actualLengths, err := TakeSlice(lengths, test.take)
+
+			switch {
+			case test.expectedPanic:
+				var panicErr *PanicError
+				assert.ErrorAs(t, err, &panicErr, "wrong error")
+				assert.True(t, strings.HasPrefix(err.Error(), "names: panic: boom"), "wrong error message")
+			case test.expectedError != nil:
+				assert.ErrorIs(t, err, test.expectedError, "wrong error")
+				assert.Equal(t, "names: "+test.expectedError.Error(), err.Error(), "wrong error message")
+			default:
+				assert.NoError(t, err, "wrong error")
+				assert.Equal(t, test.expectedLengths, actualLengths, "wrong lengths")
+			}
+		})
+	}
+
+	t.Run("stopWhenError", func(t *testing.T) {
+		names := SliceSource(context.Background(), []string{"alice", "bob"}).Named("names")
+		stopped := StopWhen(names, func(context.Context, string) (bool, error) {
+			return false, assert.AnError
+		})
+
+		_, err := TakeSlice(stopped, 2)
+
+		assert.ErrorIs(t, err, assert.AnError, "wrong error")
+		assert.Equal(t, "names: "+assert.AnError.Error(), err.Error(), "wrong error message")
+	})
+
+	t.Run("caughtMapError", func(t *testing.T) {
+		names := SliceSource(context.Background(), []string{"alice", "bob"}).Named("names")
+		lengths := Catch(Map(names, func(context.Context, string) (int, error) {
+			return 0, assert.AnError
+		}))
+
+		results, err := TakeSlice(lengths, 2)
+
+		assert.NoError(t, err, "wrong error")
+		if assert.Len(t, results, 1, "wrong results") {
+			assert.Equal(t, "names: "+assert.AnError.Error(), results[0].Err.Error(), "wrong error message")
+		}
+	})
+}
+
+func TestPollSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		idle          time.Duration
+		pollError     error
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", time.Millisecond, nil, false, []string{"alice", "bob", "charlie"}, nil},
+		{"invalidIdle", 0, nil, false, nil, fmt.Errorf("PollSource: idle must be positive, got 0s")},
+		{"pollError", time.Millisecond, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", time.Millisecond, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			pages := [][]string{{"alice", "bob"}, nil, nil, {"charlie"}}
+			var polls int
+
+			names := PollSource(ctx, func(context.Context) ([]string, error) {
+				var page []string
+				if polls < len(pages) {
+					page = pages[polls]
+				}
+				polls++
+				return page, test.pollError
+			}, test.idle)
+
+			actualNames, err := TakeSlice(names, 3)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+				assert.GreaterOrEqual(t, polls, 4, "wrong number of polls")
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		name              string
+		count             int
+		total             int
+		cancelContext     bool
+		expectedFractions []float64
+		expectedError     error
+	}{
+		{"nominal", 4, 4, false, []float64{0.25, 0.5, 0.75, 1}, nil},
+		{"overTotal", 4, 2, false, []float64{0.5, 1}, nil},
+		{"throttled", 1000, 200, false, nil, nil},
+		{"invalidTotal", 4, 0, false, nil, fmt.Errorf("Progress: total must be at least 1, got 0")},
+		{"masterContextCanceled", 4, 4, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			numbers := SliceSource(ctx, make([]int, test.count))
+
+			var actualFractions []float64
+			monitored := Progress(numbers, test.total, func(fraction float64) {
+				actualFractions = append(actualFractions, fraction)
+			})
+
+			consumed, err := DrainN(monitored, test.count+1)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.count, consumed, "wrong number consumed")
+
+				if test.expectedFractions != nil {
+					assert.Equal(t, test.expectedFractions, actualFractions, "wrong fractions")
+				} else {
+					assert.Len(t, actualFractions, 101, "wrong number of reports")
+					assert.Equal(t, 1.0, actualFractions[len(actualFractions)-1], "wrong final fraction")
+				}
+			}
+		})
+	}
+}
+
+func TestProtoSink(t *testing.T) {
+	tests := []struct {
+		name          string
+		writeError    error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"writeError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var buffer bytes.Buffer
+			var w io.Writer = &buffer
+			if test.writeError != nil {
+				w = testFailingWriter{test.writeError}
+			}
+
+			names := SliceSource(ctx, []*wrapperspb.StringValue{
+				wrapperspb.String("alice"), wrapperspb.String("bob"), wrapperspb.String("charlie"),
+			})
+			err := ProtoSink(names, w)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				messages, err := TakeSlice(ProtoSource(context.Background(), &buffer, func() *wrapperspb.StringValue {
+					return &wrapperspb.StringValue{}
+				}), 10)
+				assert.NoError(t, err, "wrong error")
+
+				var actualNames []string
+				for _, message := range messages {
+					actualNames = append(actualNames, message.GetValue())
+				}
+				assert.Equal(t, []string{"alice", "bob", "charlie"}, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestProtoSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		truncate      bool
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", false, false, []string{"alice", "bob", "charlie"}, nil},
+		{"truncated", true, false, nil, io.ErrUnexpectedEOF},
+		{"masterContextCanceled", false, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var buffer bytes.Buffer
+			for _, name := range []string{"alice", "bob", "charlie"} {
+				_, err := protodelim.MarshalTo(&buffer, wrapperspb.String(name))
+				assert.NoError(t, err, "wrong error")
+			}
+			if test.truncate {
+				buffer.Truncate(buffer.Len() - 2)
+			}
+
+			messages := ProtoSource(ctx, &buffer, func() *wrapperspb.StringValue {
+				return &wrapperspb.StringValue{}
+			})
+
+			var actualNames []string
+			err := Sink(messages, func(_ context.Context, message *wrapperspb.StringValue) error {
+				actualNames = append(actualNames, message.GetValue())
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name          string
+		window        time.Duration
+		cancelContext bool
+		expectedRates []float64
+		expectedError error
+	}{
+		{"nominal", time.Minute, false, []float64{1.0 / 60, 2.0 / 60, 3.0 / 60}, nil},
+		{"invalidWindow", 0, false, nil, fmt.Errorf("Rate: window must be positive, got 0s")},
+		{"masterContextCanceled", time.Minute, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie"})
+
+			var actualRates []float64
+			err := Sink(Rate(names, test.window), func(_ context.Context, rate float64) error {
+				actualRates = append(actualRates, rate)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.InDeltaSlice(t, test.expectedRates, actualRates, 1e-9, "wrong rates")
+			}
+		})
+	}
+
+	t.Run("expiry", func(t *testing.T) {
+		names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+			for _, name := range []string{"alice", "bob", "charlie"} {
+				if err := emit(name); err != nil {
+					return err
+				}
+				time.Sleep(40 * time.Millisecond)
+			}
+			return nil
+		})
+
+		var actualRates []float64
+		err := Sink(Rate(names, 70*time.Millisecond), func(_ context.Context, rate float64) error {
+			actualRates = append(actualRates, rate)
+			return nil
+		})
+
+		assert.NoError(t, err, "wrong error")
+		assert.InDeltaSlice(t, []float64{1 / 0.07, 2 / 0.07, 2 / 0.07}, actualRates, 1e-9, "wrong rates")
+	})
+}
+
+func TestRateTap(t *testing.T) {
+	names := SliceSource(context.Background(), []string{"alice", "bob"})
+
+	var actualRates []float64
+	tapped := RateTap(names, time.Second, func(rate float64) {
+		actualRates = append(actualRates, rate)
+	})
+
+	actualNames, err := TakeSlice(tapped, 5)
+
+	assert.NoError(t, err, "wrong error")
+	assert.Equal(t, []string{"alice", "bob"}, actualNames, "wrong names")
+	assert.Equal(t, []float64{1, 2}, actualRates, "wrong rates")
+}
+
+func TestRechunk(t *testing.T) {
+	tests := []struct {
+		name           string
+		size           int
+		cancelContext  bool
+		expectedChunks [][]int
+		expectedError  error
+	}{
+		{"nominal", 3, false, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}, nil},
+		{"exact", 7, false, [][]int{{1, 2, 3, 4, 5, 6, 7}}, nil},
+		{"invalidSize", 0, false, nil, fmt.Errorf("Rechunk: size must be at least 1, got 0")},
+		{"masterContextCanceled", 3, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			slices := SliceSource(ctx, [][]int{{1}, {2, 3, 4, 5}, {}, {6, 7}})
+
+			var actualChunks [][]int
+			err := Sink(Rechunk(slices, test.size), func(_ context.Context, chunk []int) error {
+				actualChunks = append(actualChunks, chunk)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedChunks, actualChunks, "wrong chunks")
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name          string
+		sourceError   error
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", nil, []string{"alice", "bob", "charlie"}, nil},
+		{"sourceError", assert.AnError, []string{"alice", "bob", "charlie"}, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "bob", "charlie"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+				return test.sourceError
+			})
+
+			recorded, recording := Record(context.Background(), names)
+
+			err := Sink(recorded, func(context.Context, string) error {
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			for i := 0; i < 2; i++ {
+				replayedNames, err := TakeSlice(recording.Replay(), 10)
+
+				assert.NoError(t, err, "wrong replay error")
+				assert.Equal(t, test.expectedNames, replayedNames, "wrong replayed names")
+			}
+		})
+	}
+
+	t.Run("replayContextCanceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		recorded, recording := Record(ctx, SliceSource(context.Background(), make([]int, 20)))
+
+		_, err := TakeSlice(recorded, 20)
+		assert.NoError(t, err, "wrong error")
+
+		_, err = TakeSlice(recording.Replay(), 20)
+		assert.Equal(t, context.Canceled, err, "wrong replay error")
+	})
+}
+
+func TestReduce(t *testing.T) {
+	const expectedTotalLength = 24
+
+	tests := []struct {
+		name          string
+		reduceError   error
+		expectedError error
+	}{
+		{"nominal", nil, nil},
+		{"reduceError", assert.AnError, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie", "david", "erin"})
+
+			actualTotalLength, err := Reduce(names, func(_ context.Context, name string, currentTotal int) (int, error) {
+				return currentTotal + len(name), test.reduceError
+			}, 0)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedTotalLength, actualTotalLength, "wrong total-length")
+			}
+		})
+	}
+}
+
+func TestReduceUntil(t *testing.T) {
+	tests := []struct {
+		name           string
+		amounts        []int
+		reducerError   error
+		cancelContext  bool
+		expectedTotals []int
+		expectedError  error
+	}{
+		{"nominal", []int{40, 70, 20, 90, 10, 5}, nil, false, []int{110, 110, 15}, nil},
+		{"exactFinish", []int{60, 50}, nil, false, []int{110}, nil},
+		{"empty", nil, nil, false, nil, nil},
+		{"reducerError", []int{40, 70}, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", []int{40, 70}, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			amounts := SliceSource(ctx, test.amounts)
+			totals := ReduceUntil(amounts, func(_ context.Context, total, amount int) (int, error) {
+				return total + amount, test.reducerError
+			}, func(total int) bool {
+				return total >= 100
+			}, 0)
+
+			var actualTotals []int
+			err := Sink(totals, func(_ context.Context, total int) error {
+				actualTotals = append(actualTotals, total)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedTotals, actualTotals, "wrong totals")
+			}
+		})
+	}
+}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name          string
+		interval      time.Duration
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 10 * time.Millisecond, false, nil},
+		{"invalidInterval", 0, false, fmt.Errorf("Resample: interval must be positive, got 0s")},
+		{"masterContextCanceled", 10 * time.Millisecond, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			readings := Source(ctx, func(ctx context.Context, emit func(string) error) error {
+				// A burst, of which only the last should survive, followed by a lull during which it is held.
+				for _, reading := range []string{"alice", "bob", "charlie"} {
+					if err := emit(reading); err != nil {
+						return err
+					}
+				}
+				if err := sleep(ctx, 55*time.Millisecond); err != nil {
+					return err
+				}
+
+				if err := emit("darren"); err != nil {
+					return err
+				}
+				return sleep(ctx, 35*time.Millisecond)
+			})
+
+			counts := make(map[string]int)
+			err := Sink(Resample(readings, test.interval), func(_ context.Context, reading string) error {
+				counts[reading]++
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Zero(t, counts["alice"], "burst value passed on")
+				assert.Zero(t, counts["bob"], "burst value passed on")
+				assert.GreaterOrEqual(t, counts["charlie"], 3, "value not held")
+				assert.GreaterOrEqual(t, counts["darren"], 2, "value not held")
+			}
+		})
+	}
+}
+
+func TestReservoirSample(t *testing.T) {
+	names := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		k             int
+		cancelContext bool
+		expectedCount int
+		expectedError error
+	}{
+		{"nominal", 3, false, 3, nil},
+		{"shortStream", 10, false, 5, nil},
+		{"masterContextCanceled", 3, true, 0, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			sample, err := ReservoirSample(SliceSource(ctx, names), test.k)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Len(t, sample, test.expectedCount, "wrong sample size")
+				assert.Subset(t, names, sample, "unexpected names")
+			}
+		})
+	}
+
+	t.Run("deterministic", func(t *testing.T) {
+		first, err := ReservoirSampleRand(SliceSource(context.Background(), names), 2, rand.New(rand.NewSource(42)))
+		assert.NoError(t, err, "wrong error")
+
+		second, err := ReservoirSampleRand(SliceSource(context.Background(), names), 2, rand.New(rand.NewSource(42)))
+		assert.NoError(t, err, "wrong error")
+
+		assert.Equal(t, first, second, "samples differ")
+	})
+}
+
+func TestResultChannelSource(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		resultError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"resultError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			results := make(chan Result[string], 4)
+			for _, name := range expectedNames {
+				results <- Result[string]{Value: name}
+			}
+			if test.resultError != nil {
+				results <- Result[string]{Err: test.resultError}
+			}
+			close(results)
+
+			actualNames := make([]string, 0)
+			err := Sink(ResultChannelSource(ctx, results), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestRollingReduce(t *testing.T) {
+	tests := []struct {
+		name          string
+		window        int
+		cancelContext bool
+		expectedSums  []int
+		expectedError error
+	}{
+		{"nominal", 3, false, []int{1, 3, 6, 9, 12}, nil},
+		{"single", 1, false, []int{1, 2, 3, 4, 5}, nil},
+		{"invalidWindow", 0, false, nil, fmt.Errorf("RollingReduce: window must be at least 1, got 0")},
+		{"masterContextCanceled", 3, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			numbers := SliceSource(ctx, []int{1, 2, 3, 4, 5})
+
+			sums := RollingReduce(numbers, test.window, func(sum, n int) int {
+				return sum + n
+			}, func(sum, n int) int {
+				return sum - n
+			}, 0)
+
+			var actualSums []int
+			err := Sink(sums, func(_ context.Context, sum int) error {
+				actualSums = append(actualSums, sum)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedSums, actualSums, "wrong sums")
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		name           string
+		names          []string
+		mapError       error
+		expectedValues []string
+		expectedError  error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie"}, nil, []string{"ALICE", "3", "CHARLIE"}, nil},
+		{"outOfRange", []string{"alice", "erin"}, nil, nil, fmt.Errorf("Route: index 2 out of range for 2 mappers")},
+		{"mapError", []string{"alice"}, assert.AnError, nil, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), test.names)
+
+			values := Route(names, func(name string) int {
+				switch {
+				case name == "erin":
+					return 2
+				case len(name) > 3:
+					return 0
+				default:
+					return 1
+				}
+			}, func(_ context.Context, name string) (string, error) {
+				return strings.ToUpper(name), test.mapError
+			}, func(_ context.Context, name string) (string, error) {
+				return fmt.Sprint(len(name)), nil
+			})
+
+			var actualValues []string
+			err := Sink(values, func(_ context.Context, value string) error {
+				actualValues = append(actualValues, value)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedValues, actualValues, "wrong values")
+			}
+		})
+	}
+}
+
+func TestSQLBatchSink(t *testing.T) {
+	tests := []struct {
+		name              string
+		batchSize         int
+		insertError       error
+		expectedBatches   [][]string
+		expectedCommits   int
+		expectedRollbacks int
+		expectedError     error
+	}{
+		{"nominal", 2, nil, [][]string{{"alice", "bob"}, {"charlie"}}, 2, 0, nil},
+		{"insertError", 2, assert.AnError, [][]string{{"alice", "bob"}}, 0, 1, assert.AnError},
+		{"invalidBatchSize", 0, nil, nil, 0, 0, fmt.Errorf("SQLBatchSink: batchSize must be at least 1, got 0")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connector := &testSQLConnector{}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+
+			names := SliceSource(context.Background(), []string{"alice", "bob", "charlie"})
+
+			var actualBatches [][]string
+			err := SQLBatchSink(names, db, test.batchSize, func(_ context.Context, tx *sql.Tx, batch []string) error {
+				assert.NotNil(t, tx, "missing transaction")
+				actualBatches = append(actualBatches, batch)
+				return test.insertError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedBatches, actualBatches, "wrong batches")
+			assert.Equal(t, test.expectedCommits, int(connector.commits.Load()), "wrong commits")
+			assert.Equal(t, test.expectedRollbacks, int(connector.rollbacks.Load()), "wrong rollbacks")
+		})
+	}
+}
+
+func TestSeq2Source(t *testing.T) {
+	tests := []struct {
+		name          string
+		seqError      error
+		take          int
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", nil, 5, false, []string{"alice", "bob", "charlie"}, nil},
+		{"stoppedEarly", nil, 1, false, []string{"alice"}, nil},
+		{"seqError", assert.AnError, 5, false, nil, assert.AnError},
+		{"masterContextCanceled", nil, 5, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			cleanedUp := false
+			seq := func(yield func(string, error) bool) {
+				defer func() {
+					cleanedUp = true
+				}()
+
+				for _, name := range []string{"alice", "bob"} {
+					if !yield(name, nil) {
+						return
+					}
+				}
+
+				if test.seqError != nil {
+					yield("", test.seqError)
+					return
+				}
+				yield("charlie", nil)
+			}
+
+			actualNames, err := TakeSlice(Seq2Source(ctx, seq), test.take)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.True(t, cleanedUp, "iterator not cleaned up")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	tests := []struct {
+		name             string
+		gap              time.Duration
+		cancelContext    bool
+		expectedSessions [][]int
+		expectedError    error
+	}{
+		{"nominal", 5 * time.Second, false, [][]int{{0, 3, 1, 6}, {20, 22}}, nil},
+		{"zeroGap", 0, false, [][]int{{0}, {3, 1}, {6}, {20}, {22}}, nil},
+		{"negativeGap", -time.Second, false, nil, fmt.Errorf("SessionWindow: gap must not be negative, got -1s")},
+		{"masterContextCanceled", 5 * time.Second, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			start := time.Now()
+			seconds := SliceSource(ctx, []int{0, 3, 1, 6, 20, 22})
+
+			sessions := SessionWindow(seconds, test.gap, func(s int) time.Time {
+				return start.Add(time.Duration(s) * time.Second)
+			})
+
+			var actualSessions [][]int
+			err := Sink(sessions, func(_ context.Context, session []int) error {
+				actualSessions = append(actualSessions, session)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedSessions, actualSessions, "wrong sessions")
+			}
+		})
+	}
+}
+
+func TestSingle(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		cancelContext bool
+		expectedName  string
+		expectedError error
+	}{
+		{"nominal", []string{"alice"}, false, "alice", nil},
+		{"noValues", nil, false, "", ErrNoValues},
+		{"multipleValues", []string{"alice", "bob", "charlie"}, false, "", ErrMultipleValues},
+		{"masterContextCanceled", []string{"alice"}, true, "", context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, test.names)
+
+			actualName, err := Single(names)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedName, actualName, "wrong name")
+		})
+	}
+}
+
+func TestSinkReduce(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+	const expectedTotalLength = 24
+
+	tests := []struct {
+		name          string
+		sinkError     error
+		reduceError   error
+		expectedError error
+	}{
+		{"nominal", nil, nil, nil},
+		{"sinkError", assert.AnError, nil, assert.AnError},
+		{"reduceError", nil, assert.AnError, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), expectedNames)
+
+			actualNames := make([]string, 0)
+			actualTotalLength, err := SinkReduce(names, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return test.sinkError
+			}, func(_ context.Context, currentTotal int, name string) (int, error) {
+				return currentTotal + len(name), test.reduceError
+			}, 0)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, expectedTotalLength, actualTotalLength, "wrong total-length")
+			}
+		})
+	}
+}
+
+func TestSinkTimeout(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		perItem       time.Duration
+		stallOn       string
+		sinkError     error
+		expectedError error
+	}{
+		{"nominal", 10 * time.Millisecond, "", nil, nil},
+		{"invalidPerItem", 0, "", nil, fmt.Errorf("SinkTimeout: perItem must be positive, got 0s")},
+		{"sinkError", 10 * time.Millisecond, "", assert.AnError, assert.AnError},
+		{"sinkTimeout", 10 * time.Millisecond, "bob", nil, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), expectedNames)
+
+			actualNames := make([]string, 0)
+			err := SinkTimeout(names, test.perItem, func(ctx context.Context, name string) error {
+				if name == test.stallOn {
+					<-ctx.Done()
+					time.Sleep(time.Millisecond)
+					return nil
+				}
+
+				actualNames = append(actualNames, name)
+				return test.sinkError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestSinkToResultChannel(t *testing.T) {
+	tests := []struct {
+		name            string
+		sourceError     error
+		cancelContext   bool
+		expectedResults []Result[string]
+		expectedError   error
+	}{
+		{"nominal", nil, false, []Result[string]{{Value: "alice"}, {Value: "bob"}}, nil},
+		{"sourceError", assert.AnError, false, []Result[string]{{Err: assert.AnError}}, assert.AnError},
+		{"masterContextCanceled", nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, name := range []string{"alice", "bob"} {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+				return test.sourceError
+			})
+
+			results := make(chan Result[string])
+			errs := make(chan error, 1)
+			go func() {
+				errs <- SinkToResultChannel(names, results, nil)
+				close(results)
+			}()
+
+			var actualResults []Result[string]
+			for result := range results {
+				actualResults = append(actualResults, result)
+			}
+
+			assert.Equal(t, test.expectedError, <-errs, "wrong error")
+
+			switch {
+			case test.expectedError == nil:
+				assert.Equal(t, test.expectedResults, actualResults, "wrong results")
+			case !test.cancelContext:
+				assert.Equal(t, test.expectedResults, actualResults[len(actualResults)-1:], "wrong final result")
+			}
+		})
+	}
+
+	t.Run("receiverGone", func(t *testing.T) {
+		names := Source(context.Background(), func(_ context.Context, emit func(string) error) error {
+			if err := emit("alice"); err != nil {
+				return err
+			}
+			return assert.AnError
+		})
+
+		// Nothing reads from results, so only closing done can release the final send.
+		results := make(chan Result[string])
+		done := make(chan struct{})
+		errs := make(chan error, 1)
+		go func() {
+			errs <- SinkToResultChannel(names, results, done)
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+
+		select {
+		case err := <-errs:
+			assert.Equal(t, assert.AnError, err, "wrong error")
+		case <-time.After(time.Second):
+			t.Fatal("blocked on a receiver that has gone away")
+		}
+	})
+}
+
+func TestSinkWithUnprocessed(t *testing.T) {
+	names := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name              string
+		failOn            string
+		expectedProcessed int
+		expectedError     error
+	}{
+		{"nominal", "", 5, nil},
+		{"sinkError", "charlie", 2, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processed, unprocessed, err := SinkWithUnprocessed(SliceSource(context.Background(), names), func(_ context.Context, name string) error {
+				if name == test.failOn {
+					return assert.AnError
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedProcessed, processed, "wrong processed count")
+
+			if test.expectedError == nil {
+				assert.Empty(t, unprocessed, "unexpected unprocessed names")
+			} else {
+				assert.Equal(t, test.failOn, unprocessed[0], "wrong first unprocessed name")
+				assert.Subset(t, names[test.expectedProcessed:], unprocessed, "wrong unprocessed names")
+			}
+		})
+	}
+}
+
+func TestSliceSource(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", false, nil},
+		{"masterContextCanceled", true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, expectedNames)
+
+			actualNames := make([]string, 0)
+			err := Sink(names, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestSliceSourceTracked(t *testing.T) {
+	names := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		failOn        string
+		cancelContext bool
+		expectedLast  int
+		expectedError error
+	}{
+		{"nominal", "", false, 4, nil},
+		{"sinkError", "charlie", false, 2, assert.AnError},
+		{"masterContextCanceled", "", true, -1, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			source, last := SliceSourceTracked(ctx, names)
+
+			err := Sink(source, func(_ context.Context, name string) error {
+				if name == test.failOn {
+					return assert.AnError
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedLast, last(), "wrong last index")
+		})
+	}
+}
+
+func TestSortExternal(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+
+	tests := []struct {
+		name          string
+		maxInMemory   int
+		encodeError   error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 2, nil, false, nil},
+		{"inMemory", 10, nil, false, nil},
+		{"encodeError", 2, assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", 2, nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			t.Setenv("TMPDIR", tempDir)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"david", "bob", "erin", "alice", "charlie"})
+			var codec Codec[string] = testStringCodec{encodeError: test.encodeError}
+
+			sortedNames := SortExternal(names, func(a, b string) bool {
+				return a < b
+			}, codec, test.maxInMemory)
+
+			actualNames := make([]string, 0)
+			err := Sink(sortedNames, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+
+			leftovers, _ := os.ReadDir(tempDir)
+			assert.Empty(t, leftovers, "temporary files left behind")
+		})
+	}
+
+	t.Run("manyRuns", func(t *testing.T) {
+		// One value per run yields far more runs than can be merged at once, forcing intermediate merges.
+		tempDir := t.TempDir()
+		t.Setenv("TMPDIR", tempDir)
+
+		names := make([]string, 3000)
+		for i := range names {
+			names[i] = fmt.Sprintf("%03d-%04d", i*7%1000, i)
+		}
+
+		// Only the prefix is compared, so the suffixes reveal whether equal values kept their order.
+		less := func(a, b string) bool {
+			return a[:3] < b[:3]
+		}
+
+		expectedNames := append([]string(nil), names...)
+		sort.SliceStable(expectedNames, func(i, j int) bool {
+			return less(expectedNames[i], expectedNames[j])
+		})
+
+		sortedNames := SortExternal(SliceSource(context.Background(), names), less, Codec[string](testStringCodec{}), 1)
+
+		actualNames, err := TakeSlice(sortedNames, len(names)+1)
+
+		assert.NoError(t, err, "wrong error")
+		assert.Equal(t, expectedNames, actualNames, "wrong names")
+
+		leftovers, _ := os.ReadDir(tempDir)
+		assert.Empty(t, leftovers, "temporary files left behind")
+	})
+}
+
+func TestSourceRecover(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		stalls        int
+		perRun        int
+		maxAttempts   int
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", 0, 3, 3, false, nil},
+		{"recovered", 2, 3, 3, false, nil},
+		{"exhausted", 3, 3, 3, false, assert.AnError},
+		{"progressResetsAttempts", 0, 1, 2, false, nil},
+		{"masterContextCanceled", 0, 3, 3, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			retryer := RetryerFunc(func(attempt int, _ error) (time.Duration, bool) {
+				return time.Millisecond, attempt < test.maxAttempts
+			})
+
+			var stalls, next int
+			names := SourceRecover(ctx, retryer, func(_ context.Context, emit func(string) error) error {
+				if stalls < test.stalls {
+					stalls++
+					return assert.AnError
+				}
+
+				for end := min(next+test.perRun, len(expectedNames)); next < end; next++ {
+					if err := emit(expectedNames[next]); err != nil {
+						return err
+					}
+				}
+
+				if next < len(expectedNames) {
+					return assert.AnError
+				}
+
+				return nil
+			})
+
+			var actualNames []string
+			err := Sink(names, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunks        []string
+		cancelContext bool
+		expectedLines []string
+		expectedError error
+	}{
+		{"nominal", []string{"ali", "ce\nbob\n", "char", "lie\n"}, false, []string{"alice", "bob", "charlie"}, nil},
+		{"unterminated", []string{"alice\r\nbo", "b"}, false, []string{"alice", "bob"}, nil},
+		{"emptyLines", []string{"\n\nalice\n"}, false, []string{"", "", "alice"}, nil},
+		{"masterContextCanceled", []string{"alice\n"}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			chunks := make([][]byte, len(test.chunks))
+			for i, chunk := range test.chunks {
+				chunks[i] = []byte(chunk)
+			}
+
+			var actualLines []string
+			err := Sink(SplitLines(SliceSource(ctx, chunks)), func(_ context.Context, line string) error {
+				actualLines = append(actualLines, line)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedLines, actualLines, "wrong lines")
+			}
+		})
+	}
+}
+
+func TestSplitStream(t *testing.T) {
+	expectedMatched := []string{"alice", "charlie", "david"}
+	expectedUnmatched := []string{"bob", "erin"}
+
+	tests := []struct {
+		name          string
+		predError     error
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", nil, false, nil},
+		{"predError", assert.AnError, false, assert.AnError},
+		{"masterContextCanceled", nil, true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "david", "erin"})
+
+			matched, unmatched := SplitStream(names, func(_ context.Context, name string) (bool, error) {
+				return strings.ContainsRune(name, 'a'), test.predError
+			})
+
+			actualUnmatched := make([]string, 0)
+			unmatchedErr := make(chan error, 1)
+			go func() {
+				unmatchedErr <- Sink(unmatched, func(_ context.Context, name string) error {
+					actualUnmatched = append(actualUnmatched, name)
+					return nil
+				})
+			}()
+
+			actualMatched := make([]string, 0)
+			err := Sink(matched, func(_ context.Context, name string) error {
+				actualMatched = append(actualMatched, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedError, <-unmatchedErr, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedMatched, actualMatched, "wrong matched names")
+				assert.Equal(t, expectedUnmatched, actualUnmatched, "wrong unmatched names")
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name            string
+		numbers         []int
+		cancelContext   bool
+		expectedSummary Summary
+		expectedError   error
+	}{
+		{"nominal", []int{5, 3, 7, 5, 4}, false, Summary{Count: 5, Sum: 24, Min: 3, Max: 7, Mean: 4.8}, nil},
+		{"empty", []int{}, false, Summary{}, nil},
+		{"masterContextCanceled", []int{1}, true, Summary{}, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			actualSummary, err := Stats(SliceSource(ctx, test.numbers))
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedSummary, actualSummary, "wrong summary")
+			}
+		})
+	}
+}
+
+func TestStopWhen(t *testing.T) {
+	tests := []struct {
+		name          string
+		letter        string
+		endless       bool
+		predError     error
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", "h", false, nil, false, []string{"alice", "bob"}, nil},
+		{"neverStops", "z", false, nil, false, []string{"alice", "bob", "charlie", "darren", "erin"}, nil},
+		{"endlessSource", "h", true, nil, false, []string{"alice", "bob"}, nil},
+		{"predError", "h", false, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", "h", false, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			all := []string{"alice", "bob", "charlie", "darren", "erin"}
+			names := Source(ctx, func(ctx context.Context, emit func(string) error) error {
+				for i := 0; test.endless || i < len(all); i++ {
+					if err := emit(all[i%len(all)]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			stopped := StopWhen(names, func(_ context.Context, name string) (bool, error) {
+				return strings.Contains(name, test.letter), test.predError
+			})
+
+			// A stage after StopWhen must still pass on every value it was given.
+			copied := Map(stopped, func(_ context.Context, name string) (string, error) {
+				return name, nil
+			})
+
+			var actualNames []string
+			err := Sink(copied, func(ctx context.Context, name string) error {
+				assert.NoError(t, ctx.Err(), "context canceled early")
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestStratifiedSample(t *testing.T) {
+	tests := []struct {
+		name          string
+		perKey        int
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 1, false, []string{"alice", "bob", "charlie"}, nil},
+		{"two", 2, false, []string{"alice", "bob", "anna", "charlie", "barbara"}, nil},
+		{"invalidPerKey", 0, false, nil, fmt.Errorf("StratifiedSample: perKey must be at least 1, got 0")},
+		{"masterContextCanceled", 1, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "anna", "adam", "charlie", "barbara", "brian"})
+
+			sampled := StratifiedSample(names, func(name string) byte {
+				return name[0]
+			}, test.perKey)
+
+			var actualNames []string
+			err := Sink(sampled, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestSyncMapSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		extraKey      any
+		extraValue    any
+		cancelContext bool
+		expectedPairs map[string]int
+		expectedError error
+	}{
+		{"nominal", nil, nil, false, map[string]int{"alice": 5, "bob": 3}, nil},
+		{"wrongKeyType", 7, 1, false, nil, fmt.Errorf("SyncMapSource: key 7 has type int, want string")},
+		{"wrongValueType", "charlie", "seven", false, nil, fmt.Errorf("SyncMapSource: value for key charlie has type string, want int")},
+		{"masterContextCanceled", nil, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			var m sync.Map
+			m.Store("alice", 5)
+			m.Store("bob", 3)
+			if test.extraKey != nil {
+				m.Store(test.extraKey, test.extraValue)
+			}
+
+			actualPairs := make(map[string]int)
+			err := Sink(SyncMapSource[string, int](ctx, &m), func(_ context.Context, pair Pair[string, int]) error {
+				actualPairs[pair.First] = pair.Second
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedPairs, actualPairs, "wrong pairs")
+			}
+		})
+	}
+}
+
+func TestTakeSlice(t *testing.T) {
+	tests := []struct {
+		name          string
+		n             int
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 2, false, []string{"alice", "bob"}, nil},
+		{"zero", 0, false, []string{}, nil},
+		{"shortStream", 10, false, []string{"alice", "bob", "charlie", "david", "erin"}, nil},
+		{"masterContextCanceled", 2, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "david", "erin"})
+
+			actualNames, err := TakeSlice(names, test.n)
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestTapEvery(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie", "david", "erin"}
+	expectedTaps := []string{"2:bob", "4:david"}
+
+	tests := []struct {
+		name          string
+		tapError      error
+		expectedError error
+	}{
+		{"nominal", nil, nil},
+		{"tapError", assert.AnError, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), expectedNames)
+
+			actualTaps := make([]string, 0)
+			tappedNames := TapEvery(names, 2, func(_ context.Context, count int, name string) error {
+				actualTaps = append(actualTaps, fmt.Sprintf("%d:%s", count, name))
+				return test.tapError
+			})
+
+			actualNames := make([]string, 0)
+			err := Sink(tappedNames, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+				assert.Equal(t, expectedTaps, actualTaps, "wrong taps")
+			}
+		})
+	}
+}
+
+func TestToMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		keyError      error
+		valError      error
+		expectedMap   map[byte]string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie"}, nil, nil, map[byte]string{'a': "alice", 'b': "bob", 'c': "charlie"}, nil},
+		{"duplicateKey", []string{"alice", "bob", "anna"}, nil, nil, map[byte]string{'a': "anna", 'b': "bob"}, nil},
+		{"keyError", []string{"alice"}, assert.AnError, nil, nil, assert.AnError},
+		{"valError", []string{"alice"}, nil, assert.AnError, nil, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), test.names)
+
+			actualMap, err := ToMap(names, func(_ context.Context, name string) (byte, error) {
+				return name[0], test.keyError
+			}, func(_ context.Context, name string) (string, error) {
+				return name, test.valError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedMap, actualMap, "wrong map")
+			}
+		})
+	}
+}
+
+func TestToMapCombine(t *testing.T) {
+	tests := []struct {
+		name          string
+		combineError  error
+		expectedMap   map[byte]int
+		expectedError error
+	}{
+		{"nominal", nil, map[byte]int{'a': 9, 'b': 3}, nil},
+		{"combineError", assert.AnError, nil, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), []string{"alice", "bob", "anna"})
+
+			actualMap, err := ToMapCombine(names, func(_ context.Context, name string) (byte, error) {
+				return name[0], nil
+			}, func(_ context.Context, name string) (int, error) {
+				return len(name), nil
+			}, func(_ context.Context, existing, length int) (int, error) {
+				return existing + length, test.combineError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedMap, actualMap, "wrong map")
+			}
+		})
+	}
+}
+
+func TestToMapUnique(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		expectedMap   map[byte]string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob"}, map[byte]string{'a': "alice", 'b': "bob"}, nil},
+		{"duplicateKey", []string{"alice", "bob", "anna"}, nil, ErrDuplicateKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), test.names)
+
+			actualMap, err := ToMapUnique(names, func(_ context.Context, name string) (byte, error) {
+				return name[0], nil
+			}, func(_ context.Context, name string) (string, error) {
+				return name, nil
+			})
+
+			assert.ErrorIs(t, err, test.expectedError, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedMap, actualMap, "wrong map")
+			}
+		})
+	}
+}
+
+func TestToSliceStage(t *testing.T) {
+	tests := []struct {
+		name           string
+		names          []string
+		cancelContext  bool
+		expectedSlices [][]string
+		expectedError  error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie"}, false, [][]string{{"alice", "bob", "charlie"}}, nil},
+		{"empty", []string{}, false, [][]string{{}}, nil},
+		{"masterContextCanceled", []string{"alice"}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			slices := ToSliceStage(SliceSource(ctx, test.names))
+
+			actualSlices := make([][]string, 0)
+			err := Sink(slices, func(_ context.Context, slice []string) error {
+				actualSlices = append(actualSlices, slice)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedSlices, actualSlices, "wrong slices")
+			}
+		})
+	}
+}
+
+func TestTopK(t *testing.T) {
+	tests := []struct {
+		name           string
+		k              int
+		cancelContext  bool
+		expectedScores []int
+		expectedError  error
+	}{
+		{"nominal", 3, false, []int{9, 8, 7}, nil},
+		{"shortStream", 10, false, []int{9, 8, 7, 5, 3, 2, 1}, nil},
+		{"zero", 0, false, []int{}, nil},
+		{"masterContextCanceled", 3, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			scores := SliceSource(ctx, []int{3, 7, 1, 9, 2, 8, 5})
+
+			actualScores, err := TopK(scores, test.k, func(a, b int) bool {
+				return a < b
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedScores, actualScores, "wrong scores")
+			}
+		})
+	}
+}
+
+func TestTotalLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int64
+		endless       bool
+		sinkError     error
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", 8, false, nil, false, []string{"alice", "bob"}, nil},
+		{"exact", 15, false, nil, false, []string{"alice", "bob", "charlie"}, nil},
+		{"firstExceeds", 2, false, nil, false, []string{"alice"}, nil},
+		{"neverReached", 100, false, nil, false, []string{"alice", "bob", "charlie", "darren", "erin"}, nil},
+		{"endlessSource", 31, true, nil, false, []string{"alice", "bob", "charlie", "darren", "erin", "alice", "bob"}, nil},
+		{"sinkError", 8, true, assert.AnError, false, nil, assert.AnError},
+		{"invalidLimit", 0, false, nil, false, nil, fmt.Errorf("TotalLimit: limit must be at least 1, got 0")},
+		{"masterContextCanceled", 8, false, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			all := []string{"alice", "bob", "charlie", "darren", "erin"}
+			names := Source(ctx, func(ctx context.Context, emit func(string) error) error {
+				for i := 0; test.endless || i < len(all); i++ {
+					if err := emit(all[i%len(all)]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			limited := TotalLimit(names, test.limit, func(name string) int64 {
+				return int64(len(name))
+			})
+
+			// A stage after TotalLimit must still pass on every value it was given.
+			copied := Map(limited, func(_ context.Context, name string) (string, error) {
+				return name, nil
+			})
+
+			var actualNames []string
+			err := Sink(copied, func(ctx context.Context, name string) error {
+				assert.NoError(t, ctx.Err(), "context canceled early")
+				actualNames = append(actualNames, name)
+				return test.sinkError
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestTuples2(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		strict        bool
+		cancelContext bool
+		expectedPairs []Pair[string, string]
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie", "darren"}, false, false, []Pair[string, string]{{"alice", "bob"}, {"charlie", "darren"}}, nil},
+		{"trailingDropped", []string{"alice", "bob", "charlie"}, false, false, []Pair[string, string]{{"alice", "bob"}}, nil},
+		{"trailingStrict", []string{"alice", "bob", "charlie"}, true, false, nil, ErrIncompleteTuple},
+		{"masterContextCanceled", []string{"alice", "bob"}, false, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, test.names)
+
+			var pairs Pipeline[Pair[string, string]]
+			if test.strict {
+				pairs = Tuples2Strict(names)
+			} else {
+				pairs = Tuples2(names)
+			}
+
+			var actualPairs []Pair[string, string]
+			err := Sink(pairs, func(_ context.Context, pair Pair[string, string]) error {
+				actualPairs = append(actualPairs, pair)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedPairs, actualPairs, "wrong pairs")
+			}
+		})
+	}
+}
+
+func TestTuples3(t *testing.T) {
+	tests := []struct {
+		name            string
+		names           []string
+		strict          bool
+		expectedTriples []Triple[string, string, string]
+		expectedError   error
+	}{
+		{"nominal", []string{"alice", "bob", "charlie"}, false, []Triple[string, string, string]{{"alice", "bob", "charlie"}}, nil},
+		{"trailingDropped", []string{"alice", "bob", "charlie", "darren", "erin"}, false, []Triple[string, string, string]{{"alice", "bob", "charlie"}}, nil},
+		{"trailingStrict", []string{"alice", "bob", "charlie", "darren"}, true, nil, ErrIncompleteTuple},
+		{"exactStrict", []string{"alice", "bob", "charlie"}, true, []Triple[string, string, string]{{"alice", "bob", "charlie"}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), test.names)
+
+			var triples Pipeline[Triple[string, string, string]]
+			if test.strict {
+				triples = Tuples3Strict(names)
+			} else {
+				triples = Tuples3(names)
+			}
+
+			var actualTriples []Triple[string, string, string]
+			err := Sink(triples, func(_ context.Context, triple Triple[string, string, string]) error {
+				actualTriples = append(actualTriples, triple)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedTriples, actualTriples, "wrong triples")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		validateError error
+		expectedError error
+	}{
+		{"nominal", nil, nil},
+		{"validateError", assert.AnError, assert.AnError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), expectedNames)
+
+			validNames := Validate(names, func(_ context.Context, name string) error {
+				return nil
+			}, func(_ context.Context, name string) error {
+				return test.validateError
+			})
+
+			actualNames := make([]string, 0)
+			err := Sink(validNames, func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestValidateDeadLetter(t *testing.T) {
+	errNoA := errors.New("no a")
+	errTooShort := errors.New("too short")
+
+	expectedValid := []string{"alice", "charlie", "david"}
+	expectedInvalid := map[string][]error{
+		"bob":  {errNoA, errTooShort},
+		"erin": {errNoA},
+	}
+
+	tests := []struct {
+		name          string
+		cancelContext bool
+		expectedError error
+	}{
+		{"nominal", false, nil},
+		{"masterContextCanceled", true, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, []string{"alice", "bob", "charlie", "david", "erin"})
+
+			valid, invalid := ValidateDeadLetter(names, func(_ context.Context, name string) error {
+				if !strings.ContainsRune(name, 'a') {
+					return errNoA
+				}
+				return nil
+			}, func(_ context.Context, name string) error {
+				if len(name) < 4 {
+					return errTooShort
+				}
+				return nil
+			})
+
+			actualInvalid := make(map[string]error)
+			invalidErr := make(chan error, 1)
+			go func() {
+				invalidErr <- Sink(invalid, func(_ context.Context, result Result[string]) error {
+					actualInvalid[result.Value] = result.Err
+					return nil
+				})
+			}()
+
+			actualValid := make([]string, 0)
+			err := Sink(valid, func(_ context.Context, name string) error {
+				actualValid = append(actualValid, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+			assert.Equal(t, test.expectedError, <-invalidErr, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedValid, actualValid, "wrong valid names")
+				assert.Len(t, actualInvalid, len(expectedInvalid), "wrong invalid names")
+
+				for name, errs := range expectedInvalid {
+					for _, expectedErr := range errs {
+						assert.ErrorIs(t, actualInvalid[name], expectedErr, "wrong error for %s", name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	expectedNames := []string{"alice", "bob", "charlie"}
+
+	tests := []struct {
+		name          string
+		deadline      time.Duration
+		stall         bool
+		expectedError error
+	}{
+		{"nominal", time.Minute, false, nil},
+		{"deadlineExceeded", 10 * time.Millisecond, true, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := SliceSource(context.Background(), expectedNames)
+			tail := WithDeadline(names, time.Now().Add(test.deadline))
+
+			actualNames := make([]string, 0)
+			err := Sink(tail, func(ctx context.Context, name string) error {
+				if test.stall {
+					<-ctx.Done()
+				}
+
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestWithEndMarker(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		sourceError   error
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob"}, nil, false, []string{"alice", "bob", "EOF"}, nil},
+		{"empty", nil, nil, false, []string{"EOF"}, nil},
+		{"sourceError", []string{"alice"}, assert.AnError, false, nil, assert.AnError},
+		{"masterContextCanceled", []string{"alice"}, nil, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := Source(ctx, func(_ context.Context, emit func(string) error) error {
+				for _, name := range test.names {
+					if err := emit(name); err != nil {
+						return err
+					}
+				}
+
+				return test.sourceError
+			})
+
+			var actualNames []string
+			err := Sink(WithEndMarker(names, "EOF"), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+func TestWithEndMarkerNonEmpty(t *testing.T) {
+	tests := []struct {
+		name          string
+		names         []string
+		cancelContext bool
+		expectedNames []string
+		expectedError error
+	}{
+		{"nominal", []string{"alice", "bob"}, false, []string{"alice", "bob", "EOF"}, nil},
+		{"empty", nil, false, nil, nil},
+		{"masterContextCanceled", []string{"alice"}, true, nil, context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if test.cancelContext {
+				cancel()
+			}
+
+			names := SliceSource(ctx, test.names)
+
+			var actualNames []string
+			err := Sink(WithEndMarkerNonEmpty(names, "EOF"), func(_ context.Context, name string) error {
+				actualNames = append(actualNames, name)
+				return nil
+			})
+
+			assert.Equal(t, test.expectedError, err, "wrong error")
+
+			if test.expectedError == nil {
+				assert.Equal(t, test.expectedNames, actualNames, "wrong names")
+			}
+		})
+	}
+}
+
+type testFailingReader struct {
+	err error
+}
+
+func (r testFailingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+type testFailingWriter struct {
+	err error
+}
+
+func (w testFailingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+// testIterator is an Iterator over a slice of strings that fails with nextError once the slice is exhausted.
+type testIterator struct {
+	values     []string
+	nextError  error
+	closeError error
+	closed     bool
+}
+
+func (it *testIterator) Close() error {
+	it.closed = true
+	return it.closeError
+}
+
+func (it *testIterator) Next() (string, bool, error) {
+	if len(it.values) == 0 {
+		return "", false, it.nextError
+	}
+
+	value := it.values[0]
+	it.values = it.values[1:]
+	return value, true, nil
+}
+
+// testSQLConnector is a minimal database/sql driver that supports only transactions, counting their outcomes.
+type testSQLConnector struct {
+	commits   atomic.Int32
+	rollbacks atomic.Int32
+}
+
+func (c *testSQLConnector) Begin() (driver.Tx, error) {
+	return testSQLTx{c}, nil
+}
+
+func (c *testSQLConnector) Close() error {
+	return nil
+}
+
+func (c *testSQLConnector) Connect(context.Context) (driver.Conn, error) {
+	return c, nil
+}
+
+func (c *testSQLConnector) Driver() driver.Driver {
+	return nil
+}
+
+func (c *testSQLConnector) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+type testSQLTx struct {
+	connector *testSQLConnector
+}
+
+func (tx testSQLTx) Commit() error {
+	tx.connector.commits.Add(1)
+	return nil
+}
+
+func (tx testSQLTx) Rollback() error {
+	tx.connector.rollbacks.Add(1)
+	return nil
+}
+
+// testStringCodec is a Codec writing strings with a length prefix.
+type testStringCodec struct {
+	encodeError error
+}
+
+func (c testStringCodec) Decode(r io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	value := make([]byte, length)
+	_, err := io.ReadFull(r, value)
+	return string(value), err
+}
+
+func (c testStringCodec) Encode(w io.Writer, value string) error {
+	if c.encodeError != nil {
+		return c.encodeError
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(value))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, value)
+	return err
+}
@@ -1,10 +1,12 @@
 module github.com/siggimoo/fngo
 
-go 1.19
+go 1.23
 
 require (
 	github.com/stretchr/testify v1.8.1
 	golang.org/x/sync v0.1.0
+	golang.org/x/time v0.5.0
+	google.golang.org/protobuf v1.34.2
 )
 
 require (